@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gemini-tool/protocol"
+
+	"cloud.google.com/go/vertexai/genai"
+	"go.uber.org/zap"
+)
+
+// analyzeGoCodeArgs are the arguments of analyze_go_code. Which fields are used depends on the action.
+type analyzeGoCodeArgs struct {
+	Action        string   `json:"action"`
+	Path          string   `json:"path"`
+	Symbols       []string `json:"symbols"`
+	Symbol        string   `json:"symbol"`
+	SignatureOnly bool     `json:"signature_only"`
+	ContextLines  *int     `json:"context_lines"`
+	Position      string   `json:"position"`
+	Direction     string   `json:"direction"`
+	Depth         *int     `json:"depth"`
+	Query         string   `json:"query"`
+	Kinds         []string `json:"kinds"`
+	Package       string   `json:"package"`
+	Source        string   `json:"source"`
+}
+
+// symbol returns the single symbol an action works on, accepting a one element symbols list too
+func (args analyzeGoCodeArgs) symbol() (string, error) {
+	if args.Symbol != "" {
+		return args.Symbol, nil
+	}
+	if len(args.Symbols) == 1 {
+		return args.Symbols[0], nil
+	}
+	return "", fmt.Errorf("symbol parameter is required for %s action", args.Action)
+}
+
+// goCodeAction is a single action of the analyze_go_code tool
+type goCodeAction struct {
+	name         string
+	description  string
+	pathOptional bool // the action works on the whole workspace and path only selects it
+	run          func(ctx context.Context, args analyzeGoCodeArgs) (any, error)
+}
+
+// goCodeAnalyzer implements the analyze_go_code tool on top of a shared gopls session
+type goCodeAnalyzer struct {
+	logger    *zap.Logger
+	goplsTool *GoplsTool
+	actions   []goCodeAction
+}
+
+// newAnalyzeGoCodeTool creates the analyze_go_code tool. The action enum declared to the
+// model is generated from the same table that dispatches the actions.
+func newAnalyzeGoCodeTool(logger *zap.Logger, goplsTool *GoplsTool) Tool {
+	analyzer := &goCodeAnalyzer{logger: logger, goplsTool: goplsTool}
+	analyzer.actions = []goCodeAction{
+		{
+			name:        "code_definitions",
+			description: "get code definitions for the given symbols",
+			run:         analyzer.codeDefinitions,
+		},
+		{
+			name:        "get_diagnostics",
+			description: "get compile errors and other diagnostics reported for the file",
+			run:         analyzer.diagnostics,
+		},
+		{
+			name:        "find_references",
+			description: "find every reference to a symbol across the workspace with surrounding code, grouped by package and file",
+			run:         analyzer.findReferences,
+		},
+		{
+			name:        "hover",
+			description: "get the exact type signature, doc comment and package path of a symbol or of the identifier at a position",
+			run:         analyzer.hover,
+		},
+		{
+			name:        "find_implementations",
+			description: "for an interface, list the concrete types implementing it; for a concrete type, list the interfaces it satisfies. Results include method sets and, when the workspace declares one, the Mock<Interface> mockery mock of interfaces",
+			run:         analyzer.findImplementations,
+		},
+		{
+			name:        "call_hierarchy",
+			description: "get the call tree of a function: by default the functions it calls, with the non standard library ones listed as the dependencies a test must mock; or, with direction 'incoming', its callers",
+			run:         analyzer.callHierarchy,
+		},
+		{
+			name:         "search_symbols",
+			description:  "search the whole workspace for symbols fuzzily matching a query when their file is unknown; returns the path and symbol to pass to 'code_definitions'",
+			pathOptional: true,
+			run:          analyzer.searchSymbols,
+		},
+		{
+			name:        "file_outline",
+			description: "get the outline of a file: types with their fields and methods, functions, consts and vars with line ranges. Use it on large files to choose which symbols to request with 'code_definitions'",
+			run:         analyzer.fileOutline,
+		},
+		{
+			name:        "describe_type",
+			description: "describe a type, or the type of a variable or field: fields with embedded fields, types and tags, value and pointer receiver method sets, promoted methods (including those of embedded types from other packages) and constructors",
+			run:         analyzer.describeType,
+		},
+		{
+			name:        "completion",
+			description: "list the completions at a cursor position with their kind, type or signature and doc, e.g. after 'cfg.' to see the fields and methods of cfg's type",
+			run:         analyzer.completion,
+		},
+		{
+			name:        "signature_help",
+			description: "get the signature of the function called at a cursor position inside its argument list, with parameter names and types and the parameter at the cursor",
+			run:         analyzer.signatureHelp,
+		},
+	}
+
+	actionNames := make([]string, len(analyzer.actions))
+	actionDescriptions := make([]string, len(analyzer.actions))
+	for i, action := range analyzer.actions {
+		actionNames[i] = action.name
+		actionDescriptions[i] = fmt.Sprintf("'%s' (%s)", action.name, action.description)
+	}
+
+	declaration := &genai.FunctionDeclaration{
+		Name:        "analyze_go_code",
+		Description: "Analyze Go code projects using gopls - get code definitions for symbols, find references to a symbol, get type and doc information, find interface implementations, get call hierarchies, search workspace symbols, outline files, describe types, get completions and signature help at a cursor position and get diagnostics (compile errors) for files. Results are JSON; ranges are 0-based LSP ranges unless a field says otherwise",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"action": {
+					Type:        genai.TypeString,
+					Description: "Action to perform: " + strings.Join(actionDescriptions, ", "),
+					Enum:        actionNames,
+				},
+				"path": {
+					Type:        genai.TypeString,
+					Description: "The file path to analyze. Required for every action except 'search_symbols', where it only selects the workspace to search",
+				},
+				"symbols": {
+					Type: genai.TypeArray,
+					Items: &genai.Schema{
+						Type: genai.TypeString,
+					},
+					Description: "List of symbol names to look up for code definitions (function names, struct names, etc.). Required for 'code_definitions'",
+				},
+				"query": {
+					Type:        genai.TypeString,
+					Description: "For 'search_symbols': fuzzy symbol name to search for, e.g. NewClient or Client.Do",
+				},
+				"kinds": {
+					Type:        genai.TypeArray,
+					Description: "For 'search_symbols': only return symbols of these kinds",
+					Items: &genai.Schema{
+						Type: genai.TypeString,
+						Enum: []string{"func", "method", "struct", "interface", "type", "field", "const", "var"},
+					},
+				},
+				"package": {
+					Type:        genai.TypeString,
+					Description: "For 'search_symbols': only return symbols of this package, given by name or import path",
+				},
+				"symbol": {
+					Type:        genai.TypeString,
+					Description: "The symbol to analyze for single-symbol actions such as 'find_references', 'find_implementations', 'call_hierarchy' and 'describe_type'. Accepts Func, Type, Type.Method, Type.Field or pkg.Func",
+				},
+				"position": {
+					Type:        genai.TypeString,
+					Description: "For 'hover': position of the identifier as file:line:column or line:column in path (1-based), used instead of symbol. Required for 'completion' and 'signature_help': the cursor position, e.g. right after 'cfg.' or inside the parentheses of a call",
+				},
+				"source": {
+					Type:        genai.TypeString,
+					Description: "For 'get_diagnostics', 'completion' and 'signature_help': unsaved content of the file at path, such as a test being written, to use instead of the file on disk. The file itself is not modified",
+				},
+				"context_lines": {
+					Type:        genai.TypeInteger,
+					Description: "For 'find_references': lines of code to include before and after each reference (default: 2, max: 10)",
+				},
+				"direction": {
+					Type:        genai.TypeString,
+					Description: "For 'call_hierarchy': 'outgoing' for the functions called (default) or 'incoming' for the callers",
+					Enum:        []string{"outgoing", "incoming"},
+				},
+				"depth": {
+					Type:        genai.TypeInteger,
+					Description: "For 'call_hierarchy': number of call levels to follow (default: 1, max: 5). Trees are cut short, and flagged as truncated, after 100 functions",
+				},
+				"signature_only": {
+					Type:        genai.TypeBoolean,
+					Description: "For 'code_definitions': return only signatures, not the full source, to save tokens. Defaults to false",
+				},
+			},
+			Required: []string{"action"},
+		},
+	}
+
+	return NewTypedTool(declaration, analyzer.execute)
+}
+
+// codeDefinitionsArgs are the arguments of the legacy get_code_definitions tool
+type codeDefinitionsArgs struct {
+	FilePath      string   `json:"file_path"`
+	Symbols       []string `json:"symbols"`
+	SignatureOnly bool     `json:"signature_only"`
+}
+
+// newCodeDefinitionsTool keeps the get_code_definitions tool of earlier versions working for
+// existing prompts. It is an alias of analyze_go_code with action=code_definitions.
+func newCodeDefinitionsTool(logger *zap.Logger, goplsTool *GoplsTool) Tool {
+	analyzer := &goCodeAnalyzer{logger: logger, goplsTool: goplsTool}
+
+	declaration := &genai.FunctionDeclaration{
+		Name:        "get_code_definitions",
+		Description: "Deprecated: use analyze_go_code with action 'code_definitions'. Get the code definitions of symbols in a Go file using gopls",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"file_path": {
+					Type:        genai.TypeString,
+					Description: "The Go file path to analyze",
+				},
+				"symbols": {
+					Type:        genai.TypeArray,
+					Items:       &genai.Schema{Type: genai.TypeString},
+					Description: "List of symbol names to look up (function names, struct names, etc.)",
+				},
+				"signature_only": {
+					Type:        genai.TypeBoolean,
+					Description: "Return only signatures, not the full source, to save tokens. Defaults to false",
+				},
+			},
+			Required: []string{"file_path", "symbols"},
+		},
+	}
+
+	return NewTypedTool(declaration, func(ctx context.Context, args codeDefinitionsArgs) (any, error) {
+		if args.FilePath == "" {
+			return nil, fmt.Errorf("file_path parameter is required")
+		}
+		return analyzer.codeDefinitions(ctx, analyzeGoCodeArgs{
+			Action:        "code_definitions",
+			Path:          args.FilePath,
+			Symbols:       args.Symbols,
+			SignatureOnly: args.SignatureOnly,
+		})
+	})
+}
+
+// execute dispatches the requested action
+func (a *goCodeAnalyzer) execute(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	for _, action := range a.actions {
+		if action.name != args.Action {
+			continue
+		}
+		if args.Path == "" && !action.pathOptional {
+			return nil, fmt.Errorf("path parameter is required for %s action", args.Action)
+		}
+		return action.run(ctx, args)
+	}
+	return nil, fmt.Errorf("unknown action: %s", args.Action)
+}
+
+// codeDefinitionsResult is the response of the code_definitions action
+type codeDefinitionsResult struct {
+	Path        string             `json:"path"`
+	Definitions []SymbolDefinition `json:"definitions"`
+}
+
+// codeDefinitions looks up the definitions of the requested symbols
+func (a *goCodeAnalyzer) codeDefinitions(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	if len(args.Symbols) == 0 {
+		return nil, fmt.Errorf("symbols parameter is required and must be a non-empty array for code_definitions action")
+	}
+
+	definitions, err := a.goplsTool.GetCodeDefinitions(ctx, args.Path, args.Symbols, args.SignatureOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get code definitions: %w", err)
+	}
+
+	a.logger.Info("Code definitions function executed successfully",
+		zap.String("action", args.Action),
+		zap.String("filePath", args.Path),
+		zap.Strings("symbols", args.Symbols))
+
+	return codeDefinitionsResult{
+		Path:        args.Path,
+		Definitions: definitions,
+	}, nil
+}
+
+// diagnosticsResult is the response of the get_diagnostics action
+type diagnosticsResult struct {
+	Path        string             `json:"path"`
+	ErrorCount  int                `json:"error_count"`
+	Diagnostics []DiagnosticResult `json:"diagnostics"`
+}
+
+// diagnostics reports the diagnostics gopls publishes for the file
+func (a *goCodeAnalyzer) diagnostics(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	diagnostics, err := a.goplsTool.GetDiagnostics(ctx, args.Path, args.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	errorCount := 0
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == protocol.SeverityError.String() {
+			errorCount++
+		}
+	}
+
+	return diagnosticsResult{
+		Path:        args.Path,
+		ErrorCount:  errorCount,
+		Diagnostics: diagnostics,
+	}, nil
+}
+
+// findReferences lists the references to a symbol with surrounding code
+func (a *goCodeAnalyzer) findReferences(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	symbol, err := args.symbol()
+	if err != nil {
+		return nil, err
+	}
+
+	contextLines := 2
+	if args.ContextLines != nil {
+		contextLines = min(max(*args.ContextLines, 0), 10)
+	}
+
+	return a.goplsTool.FindReferences(ctx, args.Path, symbol, contextLines)
+}
+
+// hover returns type and doc information for a symbol or a position
+func (a *goCodeAnalyzer) hover(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	if args.Position != "" {
+		return a.goplsTool.Hover(ctx, args.Path, "", args.Position)
+	}
+
+	symbol, err := args.symbol()
+	if err != nil {
+		return nil, fmt.Errorf("symbol or position parameter is required for hover action")
+	}
+
+	return a.goplsTool.Hover(ctx, args.Path, symbol, "")
+}
+
+// findImplementations relates interfaces and the types implementing them
+func (a *goCodeAnalyzer) findImplementations(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	symbol, err := args.symbol()
+	if err != nil {
+		return nil, err
+	}
+
+	return a.goplsTool.FindImplementations(ctx, args.Path, symbol)
+}
+
+// callHierarchy returns the call tree of a function
+func (a *goCodeAnalyzer) callHierarchy(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	symbol, err := args.symbol()
+	if err != nil {
+		return nil, err
+	}
+
+	direction := args.Direction
+	if direction == "" {
+		direction = "outgoing"
+	}
+
+	depth := 1
+	if args.Depth != nil {
+		depth = min(max(*args.Depth, 1), 5)
+	}
+
+	return a.goplsTool.CallHierarchy(ctx, args.Path, symbol, direction, depth)
+}
+
+// searchSymbols searches the workspace for symbols by name
+func (a *goCodeAnalyzer) searchSymbols(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	if args.Query == "" {
+		return nil, fmt.Errorf("query parameter is required for search_symbols action")
+	}
+
+	return a.goplsTool.SearchSymbols(ctx, args.Path, args.Query, args.Kinds, args.Package)
+}
+
+// fileOutline returns the declarations of a file without their bodies
+func (a *goCodeAnalyzer) fileOutline(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	return a.goplsTool.FileOutline(ctx, args.Path)
+}
+
+// describeType returns the fields, method sets and constructors of a type
+func (a *goCodeAnalyzer) describeType(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	symbol, err := args.symbol()
+	if err != nil {
+		return nil, err
+	}
+
+	return a.goplsTool.DescribeType(ctx, args.Path, symbol)
+}
+
+// completion lists the completion candidates at a cursor position
+func (a *goCodeAnalyzer) completion(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	if args.Position == "" {
+		return nil, fmt.Errorf("position parameter is required for completion action")
+	}
+
+	return a.goplsTool.Completion(ctx, args.Path, args.Position, args.Source)
+}
+
+// signatureHelp returns the signature of the call at a cursor position
+func (a *goCodeAnalyzer) signatureHelp(ctx context.Context, args analyzeGoCodeArgs) (any, error) {
+	if args.Position == "" {
+		return nil, fmt.Errorf("position parameter is required for signature_help action")
+	}
+
+	return a.goplsTool.SignatureHelp(ctx, args.Path, args.Position, args.Source)
+}
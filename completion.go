@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf16"
+
+	"gemini-tool/protocol"
+
+	"go.uber.org/zap"
+)
+
+// maxCompletionItems bounds the completion items returned to the model
+const maxCompletionItems = 50
+
+// CompletionInfo is a completion candidate at a cursor position
+type CompletionInfo struct {
+	Label         string `json:"label"`
+	Kind          string `json:"kind"`
+	Detail        string `json:"detail,omitempty"` // type of a field or variable, signature of a function
+	Documentation string `json:"documentation,omitempty"`
+	InsertText    string `json:"insert_text,omitempty"` // only set when it differs from the label
+	Deprecated    bool   `json:"deprecated,omitempty"`
+}
+
+// CompletionResult lists the completions gopls offers at a position. Line and Column are 1-based.
+type CompletionResult struct {
+	File       string           `json:"file"`
+	Line       int              `json:"line"`
+	Column     int              `json:"column"`
+	Incomplete bool             `json:"incomplete,omitempty"` // gopls stopped before listing every candidate
+	Total      int              `json:"total"`
+	Truncated  bool             `json:"truncated,omitempty"`
+	Items      []CompletionInfo `json:"items"`
+}
+
+// ParameterInfo is a parameter of a signature
+type ParameterInfo struct {
+	Label         string `json:"label"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// SignatureInfo is the signature of a called function
+type SignatureInfo struct {
+	Label           string          `json:"label"`
+	Documentation   string          `json:"documentation,omitempty"`
+	Parameters      []ParameterInfo `json:"parameters,omitempty"`
+	ActiveParameter int             `json:"active_parameter"` // 0-based index of the parameter at the cursor
+}
+
+// SignatureHelpResult is the signature of the call enclosing a position. Line and Column are 1-based.
+type SignatureHelpResult struct {
+	File            string          `json:"file"`
+	Line            int             `json:"line"`
+	Column          int             `json:"column"`
+	ActiveSignature int             `json:"active_signature"`
+	Signatures      []SignatureInfo `json:"signatures"`
+}
+
+// cursor is a position a completion or signature help request is made at
+type cursor struct {
+	file     string
+	line     int
+	column   int
+	position protocol.Position
+}
+
+// Completion returns the completion candidates at position, given as "file:line:column" or
+// "line:column" in filePath. source, when set, is the unsaved content of that file, e.g. a test
+// being written; it is sent to gopls as an overlay and the file on disk is left untouched.
+func (gt *GoplsTool) Completion(ctx context.Context, filePath, position, source string) (*CompletionResult, error) {
+	gt.logger.Debug("Requesting completion with gopls",
+		zap.String("filePath", filePath),
+		zap.String("position", position),
+		zap.Int("sourceLength", len(source)))
+
+	at, err := locateCursor(filePath, position, source)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gt.acquire(ctx, at.file)
+	if err != nil {
+		return nil, err
+	}
+
+	var completions *protocol.CompletionList
+	err = gt.withOverlay(client, at.file, source, func(uri string) error {
+		var err error
+		completions, err = client.GetCompletion(ctx, uri, at.position.Line, at.position.Character)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get completions: %w", err)
+	}
+
+	result := &CompletionResult{
+		File:       at.file,
+		Line:       at.line,
+		Column:     at.column,
+		Incomplete: completions.IsIncomplete,
+		Total:      len(completions.Items),
+		Items:      []CompletionInfo{},
+	}
+
+	for _, item := range completions.Items {
+		if len(result.Items) == maxCompletionItems {
+			result.Truncated = true
+			break
+		}
+		result.Items = append(result.Items, completionInfo(item))
+	}
+
+	gt.logger.Info("Successfully retrieved completions",
+		zap.String("file", result.File),
+		zap.Int("line", result.Line),
+		zap.Int("total", result.Total))
+
+	return result, nil
+}
+
+// SignatureHelp returns the signature of the function called at position, given as
+// "file:line:column" or "line:column" in filePath, with the parameter at the cursor.
+// source is handled as for Completion.
+func (gt *GoplsTool) SignatureHelp(ctx context.Context, filePath, position, source string) (*SignatureHelpResult, error) {
+	gt.logger.Debug("Requesting signature help with gopls",
+		zap.String("filePath", filePath),
+		zap.String("position", position),
+		zap.Int("sourceLength", len(source)))
+
+	at, err := locateCursor(filePath, position, source)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gt.acquire(ctx, at.file)
+	if err != nil {
+		return nil, err
+	}
+
+	var help *protocol.SignatureHelp
+	err = gt.withOverlay(client, at.file, source, func(uri string) error {
+		var err error
+		help, err = client.SignatureHelp(ctx, uri, at.position.Line, at.position.Character)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SignatureHelpResult{
+		File:            at.file,
+		Line:            at.line,
+		Column:          at.column,
+		ActiveSignature: help.ActiveSignature,
+		Signatures:      make([]SignatureInfo, 0, len(help.Signatures)),
+	}
+
+	for _, signature := range help.Signatures {
+		info := SignatureInfo{
+			Label:           signature.Label,
+			Documentation:   markupValue(signature.Documentation),
+			ActiveParameter: help.ActiveParameter,
+		}
+		if signature.ActiveParameter != nil {
+			info.ActiveParameter = *signature.ActiveParameter
+		}
+		for _, parameter := range signature.Parameters {
+			info.Parameters = append(info.Parameters, ParameterInfo{
+				Label:         parameterLabel(signature.Label, parameter.Label),
+				Documentation: markupValue(parameter.Documentation),
+			})
+		}
+		result.Signatures = append(result.Signatures, info)
+	}
+
+	gt.logger.Info("Successfully retrieved signature help",
+		zap.String("file", result.File),
+		zap.Int("line", result.Line),
+		zap.Int("signatures", len(result.Signatures)))
+
+	return result, nil
+}
+
+// locateCursor resolves a "file:line:column" or "line:column" position in filePath against
+// source or, when source is empty, the file on disk
+func locateCursor(filePath, position, source string) (*cursor, error) {
+	file, line, column, err := parseFilePosition(position, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		return nil, fmt.Errorf("path parameter is required with a line:column position")
+	}
+	if file, err = filepath.Abs(file); err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	content := []byte(source)
+	if source == "" {
+		if content, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+
+	return &cursor{
+		file:     file,
+		line:     line,
+		column:   column,
+		position: lineColumnToPosition(content, line, column),
+	}, nil
+}
+
+// withOverlay runs fn on the gopls document of filePath. A non-empty source replaces the
+// document content for the duration of fn; afterwards the document is reset to the file on
+// disk, or closed when the file does not exist. Calls on the same document are serialized
+// so that they never see each other's source, including calls on the file content.
+func (gt *GoplsTool) withOverlay(client *GoplsClient, filePath, source string, fn func(uri string) error) error {
+	uri := pathToURI(filePath)
+
+	lock := gt.overlayLock(uri)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if source == "" {
+		return fn(uri)
+	}
+
+	if err := client.OpenOverlay(uri, "go", source); err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.CloseOverlay(uri); err != nil {
+			gt.logger.Warn("Failed to reset document after overlay", zap.String("uri", uri), zap.Error(err))
+		}
+	}()
+
+	return fn(uri)
+}
+
+// overlayLock returns the lock serializing the overlays of a document
+func (gt *GoplsTool) overlayLock(uri string) *sync.Mutex {
+	gt.overlayMutex.Lock()
+	defer gt.overlayMutex.Unlock()
+
+	lock, ok := gt.overlays[uri]
+	if !ok {
+		lock = &sync.Mutex{}
+		gt.overlays[uri] = lock
+	}
+	return lock
+}
+
+// completionInfo converts a gopls completion item
+func completionInfo(item protocol.CompletionItem) CompletionInfo {
+	insertText := item.InsertText
+	if insertText == "" && item.TextEdit != nil {
+		insertText = item.TextEdit.NewText
+	}
+	if insertText == item.Label {
+		insertText = ""
+	}
+
+	return CompletionInfo{
+		Label:         item.Label,
+		Kind:          item.Kind.String(),
+		Detail:        item.Detail,
+		Documentation: markupValue(item.Documentation),
+		InsertText:    insertText,
+		Deprecated:    item.Deprecated,
+	}
+}
+
+// parameterLabel returns the text of a parameter label, given either as a string or as
+// UTF-16 offsets into the signature label
+func parameterLabel(signature string, label json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(label, &text); err == nil {
+		return text
+	}
+
+	var offsets [2]int
+	if err := json.Unmarshal(label, &offsets); err != nil {
+		return ""
+	}
+
+	units := utf16.Encode([]rune(signature))
+	start, end := offsets[0], offsets[1]
+	if start < 0 || end > len(units) || start > end {
+		return ""
+	}
+	return string(utf16.Decode(units[start:end]))
+}
+
+func markupValue(content *protocol.MarkupContent) string {
+	if content == nil {
+		return ""
+	}
+	return content.Value
+}
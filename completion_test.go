@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gemini-tool/protocol"
+)
+
+func TestParameterLabel(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		label     string
+		want      string
+	}{
+		{name: "string label", signature: "Println(a ...any)", label: `"a ...any"`, want: "a ...any"},
+		{name: "offsets", signature: "Copy(dst Writer, src Reader)", label: `[5, 15]`, want: "dst Writer"},
+		{name: "offsets count UTF-16 units", signature: "f(é string, 😀 int)", label: `[12, 18]`, want: "😀 int"},
+		{name: "empty range", signature: "f(a int)", label: `[2, 2]`, want: ""},
+		{name: "offsets past the end", signature: "f(a int)", label: `[2, 40]`, want: ""},
+		{name: "reversed offsets", signature: "f(a int)", label: `[5, 2]`, want: ""},
+		{name: "invalid label", signature: "f(a int)", label: `{}`, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parameterLabel(tt.signature, json.RawMessage(tt.label)); got != tt.want {
+				t.Errorf("parameterLabel(%q, %s) = %q, want %q", tt.signature, tt.label, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompletionInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		item protocol.CompletionItem
+		want CompletionInfo
+	}{
+		{
+			name: "insert text equal to the label is omitted",
+			item: protocol.CompletionItem{Label: "Println", Kind: protocol.CompletionItemKind(3), InsertText: "Println"},
+			want: CompletionInfo{Label: "Println", Kind: protocol.CompletionItemKind(3).String()},
+		},
+		{
+			name: "text edit is used when there is no insert text",
+			item: protocol.CompletionItem{
+				Label:    "Fprintf",
+				Detail:   "func(w io.Writer, format string, a ...any) (n int, err error)",
+				TextEdit: &protocol.TextEdit{NewText: "Fprintf(${1:})"},
+			},
+			want: CompletionInfo{
+				Label:      "Fprintf",
+				Kind:       protocol.CompletionItemKind(0).String(),
+				Detail:     "func(w io.Writer, format string, a ...any) (n int, err error)",
+				InsertText: "Fprintf(${1:})",
+			},
+		},
+		{
+			name: "documentation and deprecation",
+			item: protocol.CompletionItem{
+				Label:         "Title",
+				Documentation: &protocol.MarkupContent{Kind: "markdown", Value: "Deprecated: use cases."},
+				Deprecated:    true,
+			},
+			want: CompletionInfo{
+				Label:         "Title",
+				Kind:          protocol.CompletionItemKind(0).String(),
+				Documentation: "Deprecated: use cases.",
+				Deprecated:    true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := completionInfo(tt.item); got != tt.want {
+				t.Errorf("completionInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"strings"
+
+	"gemini-tool/protocol"
+)
+
+// declarationSource is the source of the declaration enclosing a location
+type declarationSource struct {
+	Name      string   // declared name; for const/var blocks the name at the location
+	Kind      string   // func, method, type, struct, interface, const or var
+	Doc       string   // doc comment, including the comment markers
+	Signature string   // func signature without body, or the full type/const/var declaration
+	Source    string   // full declaration without its doc comment
+	Methods   []string // methods of an interface, or signatures of the methods declared on a type in its package
+	Range     protocol.Range
+}
+
+// enclosingDeclaration returns the top level declaration enclosing position in filePath:
+// a whole func, a type spec together with the signatures of its methods, or a whole
+// const/var block, including the doc comment.
+func enclosingDeclaration(filePath string, position protocol.Position) (*declarationSource, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	pf, err := parseGoFile(filePath, content)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenFile := pf.fset.File(pf.file.Pos())
+	offset := positionToOffset(content, position)
+	if offset > tokenFile.Size() {
+		return nil, fmt.Errorf("position %d:%d is outside of %s", position.Line+1, position.Character+1, filePath)
+	}
+	pos := tokenFile.Pos(offset)
+
+	for _, decl := range pf.file.Decls {
+		if !declarationContains(decl, pos) {
+			continue
+		}
+
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			return funcDeclarationSource(pf, d), nil
+		case *ast.GenDecl:
+			if d.Tok == token.TYPE {
+				return typeDeclarationSource(pf, d, pos), nil
+			}
+			if d.Tok == token.CONST || d.Tok == token.VAR {
+				return valueDeclarationSource(pf, d, pos), nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no declaration encloses %s:%d:%d", filePath, position.Line+1, position.Character+1)
+}
+
+// declarationContains reports whether pos lies within decl or its doc comment
+func declarationContains(decl ast.Decl, pos token.Pos) bool {
+	start := decl.Pos()
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	}
+	return start <= pos && pos < decl.End()
+}
+
+// funcDeclarationSource builds the source of a function or method declaration
+func funcDeclarationSource(pf *parsedFile, d *ast.FuncDecl) *declarationSource {
+	kind := "func"
+	name := d.Name.Name
+	if d.Recv != nil && len(d.Recv.List) > 0 {
+		kind = "method"
+		name = receiverTypeName(d.Recv.List[0].Type) + "." + name
+	}
+
+	return &declarationSource{
+		Name:      name,
+		Kind:      kind,
+		Doc:       pf.commentText(d.Doc),
+		Signature: funcSignature(pf, d),
+		Source:    pf.source(d.Pos(), d.End()),
+		Range:     pf.rangeOf(d.Pos(), d.End()),
+	}
+}
+
+// typeDeclarationSource builds the source of the type spec enclosing pos. Specs of a grouped
+// type declaration are returned on their own, prefixed with the type keyword.
+func typeDeclarationSource(pf *parsedFile, d *ast.GenDecl, pos token.Pos) *declarationSource {
+	spec := d.Specs[0].(*ast.TypeSpec)
+	for _, s := range d.Specs {
+		if s.Pos() <= pos && pos < s.End() {
+			spec = s.(*ast.TypeSpec)
+		}
+	}
+
+	doc := d.Doc
+	start := d.Pos()
+	source := ""
+	if d.Lparen.IsValid() {
+		if spec.Doc != nil {
+			doc = spec.Doc
+		}
+		start = spec.Pos()
+		source = "type " + pf.source(spec.Pos(), spec.End())
+	} else {
+		source = pf.source(d.Pos(), d.End())
+	}
+
+	kind := "type"
+	methods := methodSignatures(pf, spec.Name.Name)
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		kind = "struct"
+	case *ast.InterfaceType:
+		kind = "interface"
+		methods = interfaceMethods(pf, t)
+	}
+
+	return &declarationSource{
+		Name:      spec.Name.Name,
+		Kind:      kind,
+		Doc:       pf.commentText(doc),
+		Signature: source,
+		Source:    source,
+		Methods:   methods,
+		Range:     pf.rangeOf(start, spec.End()),
+	}
+}
+
+// valueDeclarationSource builds the source of the whole const or var block enclosing pos
+func valueDeclarationSource(pf *parsedFile, d *ast.GenDecl, pos token.Pos) *declarationSource {
+	name := ""
+	for _, s := range d.Specs {
+		valueSpec := s.(*ast.ValueSpec)
+		if len(valueSpec.Names) > 0 && (name == "" || (valueSpec.Pos() <= pos && pos < valueSpec.End())) {
+			name = valueSpec.Names[0].Name
+		}
+	}
+
+	source := pf.source(d.Pos(), d.End())
+	return &declarationSource{
+		Name:      name,
+		Kind:      d.Tok.String(),
+		Doc:       pf.commentText(d.Doc),
+		Signature: source,
+		Source:    source,
+		Range:     pf.rangeOf(d.Pos(), d.End()),
+	}
+}
+
+// methodSignatures returns the signatures of the methods declared on typeName in the file
+// and the other files of its package
+func methodSignatures(pf *parsedFile, typeName string) []string {
+	var signatures []string
+	for _, file := range append([]*parsedFile{pf}, packageSiblings(pf)...) {
+		for _, decl := range file.file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+				continue
+			}
+			if receiverTypeName(funcDecl.Recv.List[0].Type) == typeName {
+				signatures = append(signatures, funcSignature(file, funcDecl))
+			}
+		}
+	}
+	return signatures
+}
+
+// interfaceMethods returns the methods and embedded types listed in an interface
+func interfaceMethods(pf *parsedFile, iface *ast.InterfaceType) []string {
+	var methods []string
+	for _, field := range iface.Methods.List {
+		methods = append(methods, strings.TrimSpace(pf.source(field.Pos(), field.End())))
+	}
+	return methods
+}
+
+// funcSignature returns the source of a function declaration up to its body
+func funcSignature(pf *parsedFile, d *ast.FuncDecl) string {
+	end := d.End()
+	if d.Body != nil {
+		end = d.Body.Lbrace
+	}
+	return strings.TrimSpace(pf.source(d.Pos(), end))
+}
+
+// source returns the file content between two positions
+func (pf *parsedFile) source(start, end token.Pos) string {
+	startOffset := pf.fset.Position(start).Offset
+	endOffset := pf.fset.Position(end).Offset
+	if startOffset < 0 || endOffset > len(pf.content) || startOffset > endOffset {
+		return ""
+	}
+	return string(pf.content[startOffset:endOffset])
+}
+
+// commentText returns a comment group as written in the source
+func (pf *parsedFile) commentText(group *ast.CommentGroup) string {
+	if group == nil {
+		return ""
+	}
+	return pf.source(group.Pos(), group.End())
+}
+
+// rangeOf returns the LSP range between two positions
+func (pf *parsedFile) rangeOf(start, end token.Pos) protocol.Range {
+	return protocol.Range{
+		Start: pf.position(start),
+		End:   pf.position(end),
+	}
+}
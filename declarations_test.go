@@ -0,0 +1,162 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gemini-tool/protocol"
+)
+
+const declarationsTestSource = `package sample
+
+// Client talks to the server
+type Client struct {
+	Name string
+}
+
+// Close releases the client
+func (c *Client) Close() error {
+	return nil
+}
+
+type (
+	// ID identifies a client
+	ID int
+	Handler interface {
+		Handle(id ID) error
+		Close() error
+	}
+)
+
+// Limits of the client
+const (
+	MaxClients = 10
+	MaxRetries = 3
+)
+
+func run() {}
+`
+
+func TestEnclosingDeclaration(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sample.go")
+	writeTestFile(t, file, declarationsTestSource)
+
+	tests := []struct {
+		name          string
+		position      protocol.Position // 0-based
+		wantName      string
+		wantKind      string
+		wantDoc       string
+		wantSignature string
+		wantMethods   []string
+		wantStartLine int
+	}{
+		{
+			name:          "struct with its methods",
+			position:      protocol.Position{Line: 4, Character: 2},
+			wantName:      "Client",
+			wantKind:      "struct",
+			wantDoc:       "// Client talks to the server",
+			wantSignature: "type Client struct {\n\tName string\n}",
+			wantMethods:   []string{"func (c *Client) Close() error"},
+			wantStartLine: 3,
+		},
+		{
+			name:          "doc comment belongs to the declaration",
+			position:      protocol.Position{Line: 2, Character: 5},
+			wantName:      "Client",
+			wantKind:      "struct",
+			wantDoc:       "// Client talks to the server",
+			wantSignature: "type Client struct {\n\tName string\n}",
+			wantMethods:   []string{"func (c *Client) Close() error"},
+			wantStartLine: 3,
+		},
+		{
+			name:          "method body",
+			position:      protocol.Position{Line: 9, Character: 1},
+			wantName:      "Client.Close",
+			wantKind:      "method",
+			wantDoc:       "// Close releases the client",
+			wantSignature: "func (c *Client) Close() error",
+			wantStartLine: 8,
+		},
+		{
+			name:          "spec of a grouped type declaration",
+			position:      protocol.Position{Line: 14, Character: 1},
+			wantName:      "ID",
+			wantKind:      "type",
+			wantDoc:       "// ID identifies a client",
+			wantSignature: "type ID int",
+			wantStartLine: 14,
+		},
+		{
+			name:          "interface lists its methods",
+			position:      protocol.Position{Line: 16, Character: 3},
+			wantName:      "Handler",
+			wantKind:      "interface",
+			wantSignature: "type Handler interface {\n\t\tHandle(id ID) error\n\t\tClose() error\n\t}",
+			wantMethods:   []string{"Handle(id ID) error", "Close() error"},
+			wantStartLine: 15,
+		},
+		{
+			name:          "const block",
+			position:      protocol.Position{Line: 24, Character: 2},
+			wantName:      "MaxRetries",
+			wantKind:      "const",
+			wantDoc:       "// Limits of the client",
+			wantSignature: "const (\n\tMaxClients = 10\n\tMaxRetries = 3\n)",
+			wantStartLine: 22,
+		},
+		{
+			name:          "function without doc",
+			position:      protocol.Position{Line: 27, Character: 5},
+			wantName:      "run",
+			wantKind:      "func",
+			wantSignature: "func run()",
+			wantStartLine: 27,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			declaration, err := enclosingDeclaration(file, tt.position)
+			if err != nil {
+				t.Fatalf("enclosingDeclaration(%+v) failed: %v", tt.position, err)
+			}
+
+			if declaration.Name != tt.wantName || declaration.Kind != tt.wantKind {
+				t.Errorf("got %s %s, want %s %s", declaration.Kind, declaration.Name, tt.wantKind, tt.wantName)
+			}
+			if declaration.Doc != tt.wantDoc {
+				t.Errorf("doc = %q, want %q", declaration.Doc, tt.wantDoc)
+			}
+			if declaration.Signature != tt.wantSignature {
+				t.Errorf("signature = %q, want %q", declaration.Signature, tt.wantSignature)
+			}
+			if !slices.Equal(declaration.Methods, tt.wantMethods) {
+				t.Errorf("methods = %q, want %q", declaration.Methods, tt.wantMethods)
+			}
+			if declaration.Range.Start.Line != tt.wantStartLine {
+				t.Errorf("range starts on line %d, want %d", declaration.Range.Start.Line, tt.wantStartLine)
+			}
+		})
+	}
+}
+
+func TestEnclosingDeclarationOutside(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sample.go")
+	writeTestFile(t, file, declarationsTestSource)
+
+	positions := []protocol.Position{
+		{Line: 0, Character: 3},  // package clause
+		{Line: 6, Character: 0},  // blank line between declarations
+		{Line: 99, Character: 0}, // past the end of the file
+	}
+
+	for _, position := range positions {
+		if declaration, err := enclosingDeclaration(file, position); err == nil {
+			t.Errorf("enclosingDeclaration(%+v) = %s, want an error", position, declaration.Name)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gemini-tool/protocol"
+)
+
+// TypeField is a field of a struct type
+type TypeField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Tag      string `json:"tag,omitempty"`
+	Embedded bool   `json:"embedded,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// PromotedMethod is a method promoted from an embedded field
+type PromotedMethod struct {
+	Via       string `json:"via"` // embedded field the method is promoted through, e.g. Base or Base.Logger
+	Signature string `json:"signature"`
+	// PointerOnly is set for pointer receiver methods promoted through value fields only: they
+	// belong to the method set of *T, not of T
+	PointerOnly bool `json:"pointer_only,omitempty"`
+}
+
+// TypeDescription describes a named type: its fields, its method sets and the functions constructing it
+type TypeDescription struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Package     string `json:"package"`
+	PackagePath string `json:"package_path,omitempty"`
+	File        string `json:"file"`
+	Line        int    `json:"line"` // 1-based
+	Definition  string `json:"definition"`
+	Doc         string `json:"doc,omitempty"`
+
+	Fields []TypeField `json:"fields,omitempty"`
+	// InterfaceMethods are the methods and embedded interfaces listed by an interface
+	InterfaceMethods []string `json:"interface_methods,omitempty"`
+	// ValueMethods have value receivers: they can be called on both T and *T
+	ValueMethods []string `json:"value_methods,omitempty"`
+	// PointerMethods have pointer receivers: they can only be called on *T or addressable values
+	PointerMethods []string `json:"pointer_methods,omitempty"`
+	// PromotedMethods are the methods of embedded fields that T does not shadow
+	PromotedMethods []PromotedMethod `json:"promoted_methods,omitempty"`
+	// UnresolvedEmbedded are embedded types of other packages whose methods could not be listed
+	UnresolvedEmbedded []string `json:"unresolved_embedded,omitempty"`
+	// Constructors are the package functions returning T or *T
+	Constructors []string `json:"constructors,omitempty"`
+}
+
+// embeddedTypeResolver returns the file declaring the type of another package named by the
+// identifier at position in file, e.g. Mutex in an embedded sync.Mutex
+type embeddedTypeResolver func(file string, position protocol.Position) (string, error)
+
+// describeTypeDeclaration describes the type declared at position in filePath using the
+// AST of its package. Embedded types of other packages are located with resolve; when it is
+// nil or fails, they are listed as unresolved.
+func describeTypeDeclaration(filePath string, position protocol.Position, resolve embeddedTypeResolver) (*TypeDescription, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	pf, err := parseGoFile(filePath, content)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, decl := typeSpecAt(pf, position)
+	if spec == nil {
+		return nil, fmt.Errorf("no type declaration at %s:%d:%d", filePath, position.Line+1, position.Character+1)
+	}
+
+	declaration := typeDeclarationSource(pf, decl, spec.Pos())
+	description := &TypeDescription{
+		Name:        spec.Name.Name,
+		Kind:        declaration.Kind,
+		Package:     pf.file.Name.Name,
+		PackagePath: importPath(filepath.Dir(filePath)),
+		File:        filePath,
+		Line:        pf.position(spec.Name.Pos()).Line + 1,
+		Definition:  declaration.Source,
+		Doc:         declaration.Doc,
+	}
+
+	files := append([]*parsedFile{pf}, packageSiblings(pf)...)
+
+	methods := declaredMethods(files, spec.Name.Name)
+	for _, method := range methods {
+		if method.pointer {
+			description.PointerMethods = append(description.PointerMethods, method.signature)
+		} else {
+			description.ValueMethods = append(description.ValueMethods, method.signature)
+		}
+	}
+
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		description.Fields = structFields(pf, t)
+
+		// Fields and methods of T shadow the promoted methods of the same name
+		own := make(map[string]bool)
+		for _, field := range description.Fields {
+			own[field.Name] = true
+		}
+		for _, method := range methods {
+			own[method.name] = true
+		}
+
+		var embedded []embeddedType
+		embedded, description.UnresolvedEmbedded = embeddedTypes(files, pf, spec, t, resolve)
+		description.PromotedMethods = promotedMethods(embedded, own)
+	case *ast.InterfaceType:
+		description.InterfaceMethods = interfaceMethods(pf, t)
+	}
+
+	description.Constructors = constructors(files, spec.Name.Name)
+
+	return description, nil
+}
+
+// typeSpecAt returns the type spec whose name or declaration contains position
+func typeSpecAt(pf *parsedFile, position protocol.Position) (*ast.TypeSpec, *ast.GenDecl) {
+	tokenFile := pf.fset.File(pf.file.Pos())
+	offset := positionToOffset(pf.content, position)
+	if offset > tokenFile.Size() {
+		return nil, nil
+	}
+	pos := tokenFile.Pos(offset)
+
+	for _, decl := range pf.file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || !declarationContains(genDecl, pos) {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			if typeSpec, ok := spec.(*ast.TypeSpec); ok && (len(genDecl.Specs) == 1 || (typeSpec.Pos() <= pos && pos < typeSpec.End())) {
+				return typeSpec, genDecl
+			}
+		}
+	}
+	return nil, nil
+}
+
+// structFields lists the fields of a struct with their types and tags
+func structFields(pf *parsedFile, structType *ast.StructType) []TypeField {
+	var fields []TypeField
+	for _, field := range structType.Fields.List {
+		typeSource := pf.source(field.Type.Pos(), field.Type.End())
+
+		tag := ""
+		if field.Tag != nil {
+			if unquoted, err := strconv.Unquote(field.Tag.Value); err == nil {
+				tag = unquoted
+			}
+		}
+
+		comment := ""
+		if field.Doc != nil {
+			comment = strings.TrimSpace(field.Doc.Text())
+		} else if field.Comment != nil {
+			comment = strings.TrimSpace(field.Comment.Text())
+		}
+
+		if len(field.Names) == 0 {
+			name := ""
+			if ident := embeddedFieldIdent(field.Type); ident != nil {
+				name = ident.Name
+			}
+			fields = append(fields, TypeField{Name: name, Type: typeSource, Tag: tag, Embedded: true, Comment: comment})
+			continue
+		}
+
+		for _, name := range field.Names {
+			fields = append(fields, TypeField{Name: name.Name, Type: typeSource, Tag: tag, Comment: comment})
+		}
+	}
+	return fields
+}
+
+// declaredMethod is a method declared on a type, or listed by an interface
+type declaredMethod struct {
+	name      string
+	signature string
+	pointer   bool // declared with a pointer receiver
+}
+
+// declaredMethods lists the methods declared on typeName across the package files
+func declaredMethods(files []*parsedFile, typeName string) []declaredMethod {
+	var methods []declaredMethod
+	for _, file := range files {
+		for _, decl := range file.file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+				continue
+			}
+
+			receiver := funcDecl.Recv.List[0].Type
+			if receiverTypeName(receiver) != typeName {
+				continue
+			}
+
+			_, pointer := unparen(receiver).(*ast.StarExpr)
+			methods = append(methods, declaredMethod{
+				name:      funcDecl.Name.Name,
+				signature: funcSignature(file, funcDecl),
+				pointer:   pointer,
+			})
+		}
+	}
+	return methods
+}
+
+// embeddedType is a type embedded in a struct, directly or through other embedded fields
+type embeddedType struct {
+	via     string        // embedded fields leading to it, e.g. Base.Logger
+	pointer bool          // reached through at least one pointer field
+	foreign bool          // declared in another package, so only its exported methods are promoted
+	files   []*parsedFile // files of the package declaring it
+	file    *parsedFile   // file declaring it
+	spec    *ast.TypeSpec
+}
+
+// methods lists the methods of the embedded type: those declared on it and, for an interface,
+// those it lists
+func (e embeddedType) methods() []declaredMethod {
+	methods := declaredMethods(e.files, e.spec.Name.Name)
+	if iface, ok := e.spec.Type.(*ast.InterfaceType); ok {
+		for _, field := range iface.Methods.List {
+			for _, name := range field.Names {
+				methods = append(methods, declaredMethod{
+					name:      name.Name,
+					signature: strings.TrimSpace(e.file.source(field.Pos(), field.End())),
+				})
+			}
+		}
+	}
+
+	if !e.foreign {
+		return methods
+	}
+	exported := methods[:0]
+	for _, method := range methods {
+		if ast.IsExported(method.name) {
+			exported = append(exported, method)
+		}
+	}
+	return exported
+}
+
+// embeddedTypes lists the types embedded in the struct of spec, breadth first, following the
+// embedded structs. Types of other packages are located with resolve; the ones that cannot be
+// are returned as unresolved.
+func embeddedTypes(files []*parsedFile, file *parsedFile, spec *ast.TypeSpec, structType *ast.StructType, resolve embeddedTypeResolver) ([]embeddedType, []string) {
+	type pendingStruct struct {
+		parent     *embeddedType // nil for the described struct
+		files      []*parsedFile
+		file       *parsedFile
+		structType *ast.StructType
+	}
+
+	// Types of other packages are parsed again for every field embedding them, so types are
+	// identified by package directory and name
+	key := func(file *parsedFile, spec *ast.TypeSpec) string {
+		return filepath.Dir(file.path) + "." + spec.Name.Name
+	}
+
+	var embedded []embeddedType
+	var unresolved []string
+	seen := map[string]bool{key(file, spec): true}
+	queue := []pendingStruct{{files: files, file: file, structType: structType}}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, field := range current.structType.Fields.List {
+			if len(field.Names) > 0 {
+				continue
+			}
+
+			ident := embeddedFieldIdent(field.Type)
+			if ident == nil {
+				continue
+			}
+			_, pointer := unparen(field.Type).(*ast.StarExpr)
+
+			next := embeddedType{via: ident.Name, pointer: pointer, files: current.files}
+			if current.parent != nil {
+				next.via = current.parent.via + "." + ident.Name
+				next.pointer = next.pointer || current.parent.pointer
+				next.foreign = current.parent.foreign
+			}
+
+			if _, qualified := embeddedTypeName(field.Type).(*ast.SelectorExpr); qualified {
+				next.foreign = true
+				next.files = nil
+				if resolve != nil {
+					if declFile, err := resolve(current.file.path, current.file.position(ident.Pos())); err == nil {
+						next.files = packageFiles(declFile)
+					}
+				}
+			}
+
+			next.spec, next.file = findTypeSpec(next.files, ident.Name)
+			if next.spec == nil {
+				if _, qualified := embeddedTypeName(field.Type).(*ast.SelectorExpr); qualified {
+					unresolved = append(unresolved, strings.TrimSpace(current.file.source(field.Type.Pos(), field.Type.End())))
+				}
+				continue // predeclared types such as error, or type parameters
+			}
+			if seen[key(next.file, next.spec)] {
+				continue
+			}
+			seen[key(next.file, next.spec)] = true
+
+			embedded = append(embedded, next)
+			if nested, ok := next.spec.Type.(*ast.StructType); ok {
+				parent := next
+				queue = append(queue, pendingStruct{parent: &parent, files: next.files, file: next.file, structType: nested})
+			}
+		}
+	}
+
+	return embedded, unresolved
+}
+
+// promotedMethods lists the methods promoted through embedded types, in the order the types
+// were found. A method is left out when own holds its name, when a shallower embedded type has
+// a method of the same name, or when several types at the same depth do, making it ambiguous.
+func promotedMethods(embedded []embeddedType, own map[string]bool) []PromotedMethod {
+	type candidate struct {
+		via    *embeddedType
+		method declaredMethod
+		depth  int
+	}
+
+	var candidates []candidate
+	shallowest := make(map[string]int)
+	atDepth := make(map[string]int) // number of candidates at the shallowest depth, by name
+	for i := range embedded {
+		depth := strings.Count(embedded[i].via, ".")
+		for _, method := range embedded[i].methods() {
+			candidates = append(candidates, candidate{via: &embedded[i], method: method, depth: depth})
+
+			current, ok := shallowest[method.name]
+			switch {
+			case !ok || depth < current:
+				shallowest[method.name] = depth
+				atDepth[method.name] = 1
+			case depth == current:
+				atDepth[method.name]++
+			}
+		}
+	}
+
+	var promoted []PromotedMethod
+	for _, c := range candidates {
+		name := c.method.name
+		if own[name] || c.depth != shallowest[name] || atDepth[name] > 1 {
+			continue
+		}
+		promoted = append(promoted, PromotedMethod{
+			Via:         c.via.via,
+			Signature:   c.method.signature,
+			PointerOnly: c.method.pointer && !c.via.pointer,
+		})
+	}
+	return promoted
+}
+
+// constructors lists the package functions whose results include typeName or a pointer to it
+func constructors(files []*parsedFile, typeName string) []string {
+	var signatures []string
+	for _, file := range files {
+		for _, decl := range file.file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv != nil || funcDecl.Type.Results == nil {
+				continue
+			}
+
+			for _, result := range funcDecl.Type.Results.List {
+				if receiverTypeName(result.Type) == typeName {
+					signatures = append(signatures, funcSignature(file, funcDecl))
+					break
+				}
+			}
+		}
+	}
+	return signatures
+}
+
+// findTypeSpec returns the type spec named typeName declared in the package files, and the
+// file declaring it
+func findTypeSpec(files []*parsedFile, typeName string) (*ast.TypeSpec, *parsedFile) {
+	for _, file := range files {
+		for _, decl := range file.file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				if typeSpec, ok := spec.(*ast.TypeSpec); ok && typeSpec.Name.Name == typeName {
+					return typeSpec, file
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// packageFiles parses a Go file and the other files of its package
+func packageFiles(filePath string) []*parsedFile {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+	pf, err := parseGoFile(filePath, content)
+	if err != nil {
+		return nil
+	}
+	return append([]*parsedFile{pf}, packageSiblings(pf)...)
+}
+
+// embeddedTypeName strips the pointer and type arguments of an embedded field type
+func embeddedTypeName(expr ast.Expr) ast.Expr {
+	switch t := unparen(expr).(type) {
+	case *ast.StarExpr:
+		return embeddedTypeName(t.X)
+	case *ast.IndexExpr:
+		return embeddedTypeName(t.X)
+	case *ast.IndexListExpr:
+		return embeddedTypeName(t.X)
+	default:
+		return t
+	}
+}
+
+func unparen(expr ast.Expr) ast.Expr {
+	if paren, ok := expr.(*ast.ParenExpr); ok {
+		return unparen(paren.X)
+	}
+	return expr
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gemini-tool/protocol"
+)
+
+const describeTestSource = `package sample
+
+import "example.com/lib"
+
+type Base struct{}
+
+func (b Base) Name() string { return "" }
+
+func (b *Base) SetName(name string) {}
+
+func (b Base) Close() error { return nil }
+
+type Inner struct{}
+
+func (i Inner) Depth() int { return 0 }
+
+func (i Inner) Name() string { return "" }
+
+type Nested struct {
+	Inner
+}
+
+type Other struct{}
+
+func (o Other) Name() string { return "" }
+
+type Logger interface {
+	Log(msg string)
+}
+
+type Service struct {
+	Base
+	*Nested
+	Logger
+	lib.Mutex
+	Close string
+}
+
+func (s *Service) Run() error { return nil }
+
+func (s Service) String() string { return "" }
+
+type Custom struct {
+	Base
+}
+
+func (c Custom) Name() string { return "custom" }
+
+type Handle struct {
+	*Base
+}
+
+type Pair struct {
+	Base
+	Other
+}
+`
+
+const describeTestLibrary = `package lib
+
+type Mutex struct{}
+
+func (m *Mutex) Lock() {}
+
+func (m *Mutex) Unlock() {}
+
+func (m *Mutex) lockSlow() {}
+`
+
+func TestDescribeTypeDeclaration(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sample.go")
+	writeTestFile(t, file, describeTestSource)
+
+	libDir := filepath.Join(dir, "lib")
+	if err := os.Mkdir(libDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	libFile := filepath.Join(libDir, "mutex.go")
+	writeTestFile(t, libFile, describeTestLibrary)
+
+	// resolve stands in for gopls: every embedded type of another package is lib.Mutex
+	resolve := func(file string, position protocol.Position) (string, error) {
+		return libFile, nil
+	}
+	failing := func(file string, position protocol.Position) (string, error) {
+		return "", errors.New("gopls is not running")
+	}
+
+	tests := []struct {
+		name           string
+		typeName       string
+		resolve        embeddedTypeResolver
+		wantValue      []string
+		wantPointer    []string
+		wantPromoted   []PromotedMethod
+		wantUnresolved []string
+	}{
+		{
+			name:        "value and pointer method sets",
+			typeName:    "Base",
+			wantValue:   []string{"func (b Base) Name() string", "func (b Base) Close() error"},
+			wantPointer: []string{"func (b *Base) SetName(name string)"},
+		},
+		{
+			name:        "embedding, shadowing and other packages",
+			typeName:    "Service",
+			resolve:     resolve,
+			wantValue:   []string{"func (s Service) String() string"},
+			wantPointer: []string{"func (s *Service) Run() error"},
+			wantPromoted: []PromotedMethod{
+				{Via: "Base", Signature: "func (b Base) Name() string"},
+				{Via: "Base", Signature: "func (b *Base) SetName(name string)", PointerOnly: true},
+				{Via: "Logger", Signature: "Log(msg string)"},
+				{Via: "Mutex", Signature: "func (m *Mutex) Lock()", PointerOnly: true},
+				{Via: "Mutex", Signature: "func (m *Mutex) Unlock()", PointerOnly: true},
+				{Via: "Nested.Inner", Signature: "func (i Inner) Depth() int"},
+			},
+		},
+		{
+			name:        "other packages without a resolver",
+			typeName:    "Service",
+			wantValue:   []string{"func (s Service) String() string"},
+			wantPointer: []string{"func (s *Service) Run() error"},
+			wantPromoted: []PromotedMethod{
+				{Via: "Base", Signature: "func (b Base) Name() string"},
+				{Via: "Base", Signature: "func (b *Base) SetName(name string)", PointerOnly: true},
+				{Via: "Logger", Signature: "Log(msg string)"},
+				{Via: "Nested.Inner", Signature: "func (i Inner) Depth() int"},
+			},
+			wantUnresolved: []string{"lib.Mutex"},
+		},
+		{
+			name:        "resolver failure",
+			typeName:    "Service",
+			resolve:     failing,
+			wantValue:   []string{"func (s Service) String() string"},
+			wantPointer: []string{"func (s *Service) Run() error"},
+			wantPromoted: []PromotedMethod{
+				{Via: "Base", Signature: "func (b Base) Name() string"},
+				{Via: "Base", Signature: "func (b *Base) SetName(name string)", PointerOnly: true},
+				{Via: "Logger", Signature: "Log(msg string)"},
+				{Via: "Nested.Inner", Signature: "func (i Inner) Depth() int"},
+			},
+			wantUnresolved: []string{"lib.Mutex"},
+		},
+		{
+			name:      "method shadows promoted method",
+			typeName:  "Custom",
+			wantValue: []string{`func (c Custom) Name() string`},
+			wantPromoted: []PromotedMethod{
+				{Via: "Base", Signature: "func (b *Base) SetName(name string)", PointerOnly: true},
+				{Via: "Base", Signature: "func (b Base) Close() error"},
+			},
+		},
+		{
+			name:     "pointer embedding promotes pointer methods to the value",
+			typeName: "Handle",
+			wantPromoted: []PromotedMethod{
+				{Via: "Base", Signature: "func (b Base) Name() string"},
+				{Via: "Base", Signature: "func (b *Base) SetName(name string)"},
+				{Via: "Base", Signature: "func (b Base) Close() error"},
+			},
+		},
+		{
+			name:     "ambiguous methods are not promoted",
+			typeName: "Pair",
+			wantPromoted: []PromotedMethod{
+				{Via: "Base", Signature: "func (b *Base) SetName(name string)", PointerOnly: true},
+				{Via: "Base", Signature: "func (b Base) Close() error"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.Index(describeTestSource, "type "+tt.typeName+" ")
+			position := offsetToPosition([]byte(describeTestSource), offset+len("type "))
+
+			description, err := describeTypeDeclaration(file, position, tt.resolve)
+			if err != nil {
+				t.Fatalf("describeTypeDeclaration() failed: %v", err)
+			}
+
+			if description.Name != tt.typeName {
+				t.Errorf("Name = %q, want %q", description.Name, tt.typeName)
+			}
+			if !reflect.DeepEqual(description.ValueMethods, tt.wantValue) {
+				t.Errorf("ValueMethods = %q, want %q", description.ValueMethods, tt.wantValue)
+			}
+			if !reflect.DeepEqual(description.PointerMethods, tt.wantPointer) {
+				t.Errorf("PointerMethods = %q, want %q", description.PointerMethods, tt.wantPointer)
+			}
+			if !reflect.DeepEqual(description.PromotedMethods, tt.wantPromoted) {
+				t.Errorf("PromotedMethods = %+v, want %+v", description.PromotedMethods, tt.wantPromoted)
+			}
+			if !reflect.DeepEqual(description.UnresolvedEmbedded, tt.wantUnresolved) {
+				t.Errorf("UnresolvedEmbedded = %q, want %q", description.UnresolvedEmbedded, tt.wantUnresolved)
+			}
+		})
+	}
+}
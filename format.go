@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gemini-tool/protocol"
+
+	"go.uber.org/zap"
+)
+
+// FormatResult is Go source cleaned by format_go
+type FormatResult struct {
+	Path      string `json:"path,omitempty"`
+	Formatter string `json:"formatter"` // "gopls" (formatting and organize imports) or "go/format"
+	Changed   bool   `json:"changed"`
+	Written   bool   `json:"written,omitempty"`
+	Warning   string `json:"warning,omitempty"`
+	Source    string `json:"source"`
+}
+
+// FormatGo formats Go source and organizes its imports with gopls. source is formatted as the
+// content of filePath, so imports resolve against its package, without touching the file; an
+// empty source formats the file itself. When gopls is unavailable, or without filePath, the
+// source is only formatted with go/format, which also accepts declaration snippets.
+// With write, the result replaces filePath on disk.
+func (gt *GoplsTool) FormatGo(ctx context.Context, filePath, source string, write bool) (*FormatResult, error) {
+	gt.logger.Debug("Formatting Go source",
+		zap.String("filePath", filePath),
+		zap.Int("sourceLength", len(source)),
+		zap.Bool("write", write))
+
+	if filePath != "" {
+		var err error
+		if filePath, err = filepath.Abs(filePath); err != nil {
+			return nil, fmt.Errorf("invalid file path: %w", err)
+		}
+	}
+
+	if source == "" {
+		if filePath == "" {
+			return nil, fmt.Errorf("either a path or source is required")
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		source = string(content)
+	}
+
+	result := &FormatResult{Path: filePath}
+
+	formatted, err := "", fmt.Errorf("no file path to resolve imports against")
+	if filePath != "" {
+		formatted, err = gt.formatWithGopls(ctx, filePath, source)
+	}
+
+	if err == nil {
+		result.Formatter = "gopls"
+	} else {
+		if filePath != "" {
+			gt.logger.Warn("gopls formatting failed, falling back to go/format", zap.Error(err))
+		}
+
+		fallback, fallbackErr := format.Source([]byte(source))
+		if fallbackErr != nil {
+			return nil, fmt.Errorf("failed to format source: %w", fallbackErr)
+		}
+		formatted = string(fallback)
+		result.Formatter = "go/format"
+		result.Warning = "imports were not organized: " + err.Error()
+	}
+
+	result.Source = formatted
+	result.Changed = formatted != source
+
+	if write && filePath != "" {
+		if err := writeFileAtomic(filePath, []byte(formatted)); err != nil {
+			return nil, err
+		}
+		result.Written = true
+		gt.syncWrittenFile(filePath, formatted)
+	}
+
+	gt.logger.Info("Formatted Go source",
+		zap.String("filePath", filePath),
+		zap.String("formatter", result.Formatter),
+		zap.Bool("changed", result.Changed))
+
+	return result, nil
+}
+
+// formatWithGopls organizes the imports of source and formats it as the content of filePath.
+// The buffer is opened in gopls as an overlay and the document is reset to the file on disk afterwards.
+func (gt *GoplsTool) formatWithGopls(ctx context.Context, filePath, source string) (string, error) {
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	content := []byte(source)
+	err = gt.withOverlay(client, filePath, source, func(uri string) error {
+		whole := protocol.Range{End: offsetToPosition(content, len(content))}
+
+		actions, err := client.CodeActions(ctx, uri, whole, nil, []string{"source.organizeImports"})
+		if err != nil {
+			return fmt.Errorf("failed to organize imports: %w", err)
+		}
+
+		for _, action := range actions {
+			if action.Kind != "source.organizeImports" || action.Edit == nil {
+				continue
+			}
+
+			if content, err = applyTextEdits(content, workspaceEditsFor(*action.Edit, uri)); err != nil {
+				return fmt.Errorf("failed to organize imports: %w", err)
+			}
+			if err := client.OpenOverlay(uri, "go", string(content)); err != nil {
+				return err
+			}
+			break
+		}
+
+		edits, err := client.Formatting(ctx, uri)
+		if err != nil {
+			return fmt.Errorf("failed to format: %w", err)
+		}
+
+		if content, err = applyTextEdits(content, edits); err != nil {
+			return fmt.Errorf("failed to format: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// syncWrittenFile tells gopls that a file was rewritten on disk
+func (gt *GoplsTool) syncWrittenFile(filePath, content string) {
+	gt.mutex.Lock()
+	client := gt.goplsClient
+	gt.mutex.Unlock()
+
+	if client == nil || !client.IsAlive() {
+		return
+	}
+
+	// The file is written, so gopls is synced with it even if the call was cancelled
+	uri := pathToURI(filePath)
+	if client.IsOpen(uri) {
+		if err := client.DidOpen(context.Background(), uri, "go", content); err != nil {
+			gt.logger.Warn("Failed to sync written file with gopls", zap.String("uri", uri), zap.Error(err))
+		}
+	}
+	if err := client.DidChangeWatchedFiles([]protocol.FileEvent{{URI: uri, Type: protocol.FileChanged}}); err != nil {
+		gt.logger.Warn("Failed to notify gopls of written file", zap.Error(err))
+	}
+}
+
+// workspaceEditsFor returns the text edits a workspace edit makes to one document
+func workspaceEditsFor(edit protocol.WorkspaceEdit, uri string) []protocol.TextEdit {
+	edits := edit.Changes[uri]
+	for _, change := range edit.DocumentChanges {
+		if change.Kind == "" && change.TextDocument != nil && change.TextDocument.URI == uri {
+			edits = append(edits, change.Edits...)
+		}
+	}
+	return edits
+}
+
+var (
+	// yamlBlockKey matches a YAML key introducing a literal block scalar, e.g. "  test_code: |"
+	yamlBlockKey = regexp.MustCompile(`^(\s*(?:-\s+)?)([A-Za-z_]+):\s*\|[-+]?\s*$`)
+	// numberedLine matches a line of a line-numbered source listing, e.g. "12     return nil"
+	numberedLine = regexp.MustCompile(`^(\d+)(?: (.*))?$`)
+)
+
+// formatGeneratedCode formats the Go code blocks of a generated YAML answer before it is
+// written out: every test_code block, and the refactored_source_code file block when
+// formatting keeps its line count, since replacements refer to its line numbers.
+// Blocks that fail to format are left untouched. sourcePath, when known, is the file the
+// refactored source replaces; it lets gopls organize the imports of the refactored source,
+// and of the tests as a _test.go file next to it. Without it the blocks are only formatted
+// with go/format; with it, blocks gopls could not organize are marked with a comment.
+func (gc *GeminiClient) formatGeneratedCode(ctx context.Context, text, sourcePath string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	inRefactoredSource := false
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		out = append(out, line)
+
+		match := yamlBlockKey.FindStringSubmatch(line)
+		if match == nil {
+			if key, _, ok := strings.Cut(strings.TrimSpace(line), ":"); ok && indentOf(line) == 0 && key != "" {
+				inRefactoredSource = key == "refactored_source_code"
+			}
+			continue
+		}
+
+		keyIndent := len(match[1])
+		key := match[2]
+		if keyIndent == 0 {
+			inRefactoredSource = false
+		}
+
+		end := i + 1
+		for end < len(lines) && (strings.TrimSpace(lines[end]) == "" || indentOf(lines[end]) > keyIndent) {
+			end++
+		}
+		// Trailing blank lines belong to what follows the block
+		for end > i+1 && strings.TrimSpace(lines[end-1]) == "" {
+			end--
+		}
+		block := lines[i+1 : end]
+
+		var formatted []string
+		var organized bool
+		var err error
+		switch {
+		case key == "test_code":
+			formatted, organized, err = gc.formatYAMLCodeBlock(ctx, block, false, testPathFor(sourcePath))
+		case key == "file" && inRefactoredSource:
+			formatted, organized, err = gc.formatYAMLCodeBlock(ctx, block, true, sourcePath)
+		default:
+			continue
+		}
+
+		if err != nil {
+			gc.logger.Warn("Leaving generated code block unformatted",
+				zap.String("key", key),
+				zap.Error(err))
+			continue
+		}
+
+		if !organized && sourcePath != "" {
+			out[len(out)-1] = line + " " + importsNotOrganizedComment
+		}
+		out = append(out, formatted...)
+		i = end - 1
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// importsNotOrganizedComment marks a generated code block whose imports gopls could not organize
+const importsNotOrganizedComment = "# formatted with go/format, imports were not organized"
+
+// testPathFor returns the path of the test file of a Go source file, or "" when it is unknown
+func testPathFor(sourcePath string) string {
+	if sourcePath == "" || strings.HasSuffix(sourcePath, "_test.go") {
+		return sourcePath
+	}
+	return strings.TrimSuffix(sourcePath, ".go") + "_test.go"
+}
+
+// formatYAMLCodeBlock formats the lines of a YAML block scalar holding Go code. numbered
+// blocks carry a line number prefix that is stripped before formatting and added back after;
+// they are only rewritten when the number of lines does not change. The code is formatted
+// with gopls as the content of goPath when it is set; organized reports whether gopls
+// organized its imports, rather than go/format only formatting it.
+func (gc *GeminiClient) formatYAMLCodeBlock(ctx context.Context, block []string, numbered bool, goPath string) (lines []string, organized bool, err error) {
+	indent := -1
+	for _, line := range block {
+		if strings.TrimSpace(line) != "" && (indent < 0 || indentOf(line) < indent) {
+			indent = indentOf(line)
+		}
+	}
+	if indent < 0 {
+		return nil, false, fmt.Errorf("empty block")
+	}
+	prefix := strings.Repeat(" ", indent)
+
+	code := make([]string, len(block))
+	for i, line := range block {
+		if len(line) >= indent {
+			line = line[indent:]
+		} else {
+			line = ""
+		}
+
+		if numbered {
+			match := numberedLine.FindStringSubmatch(line)
+			if match == nil {
+				return nil, false, fmt.Errorf("line %d is not numbered", i+1)
+			}
+			if number, _ := strconv.Atoi(match[1]); number != i+1 {
+				return nil, false, fmt.Errorf("line %d is numbered %d", i+1, number)
+			}
+			line = dedentNumbered(match[2], len(strconv.Itoa(len(block)))-len(match[1]))
+		}
+		code[i] = line
+	}
+
+	source := strings.Join(code, "\n") + "\n"
+
+	var formatted string
+	if goPath != "" && gc.goplsTool != nil {
+		result, err := gc.goplsTool.FormatGo(ctx, goPath, source, false)
+		if err != nil {
+			return nil, false, err
+		}
+		formatted = result.Source
+		organized = result.Formatter == "gopls"
+	} else {
+		result, err := format.Source([]byte(source))
+		if err != nil {
+			return nil, false, err
+		}
+		formatted = string(result)
+		gc.logger.Debug("Formatting generated code without gopls, imports are not organized",
+			zap.Bool("goplsAvailable", gc.goplsTool != nil),
+			zap.String("path", goPath))
+	}
+
+	formattedLines := strings.Split(strings.TrimRight(formatted, "\n"), "\n")
+	if numbered && len(formattedLines) != len(block) {
+		return nil, false, fmt.Errorf("formatting changes the line count from %d to %d", len(block), len(formattedLines))
+	}
+
+	width := len(strconv.Itoa(len(formattedLines)))
+	out := make([]string, len(formattedLines))
+	for i, line := range formattedLines {
+		if numbered {
+			line = strings.TrimRight(fmt.Sprintf("%-*d %s", width, i+1, line), " ")
+		}
+		if strings.TrimSpace(line) == "" {
+			out[i] = ""
+		} else {
+			out[i] = prefix + line
+		}
+	}
+	return out, organized, nil
+}
+
+// dedentNumbered removes the padding that aligns the code of shorter line numbers
+func dedentNumbered(line string, padding int) string {
+	for ; padding > 0 && strings.HasPrefix(line, " "); padding-- {
+		line = line[1:]
+	}
+	return line
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
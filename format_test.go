@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestFormatGeneratedCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		sourcePath string
+		want       string
+	}{
+		{
+			name: "test code is formatted with go/format",
+			text: "tests:\n  - name: TestAdd\n    test_code: |\n      func TestAdd(t *testing.T) {\n      if add(1,2)!=3 {\n      t.Fail()\n      }\n      }\n    notes: none",
+			want: "tests:\n  - name: TestAdd\n    test_code: |\n      func TestAdd(t *testing.T) {\n      \tif add(1, 2) != 3 {\n      \t\tt.Fail()\n      \t}\n      }\n    notes: none",
+		},
+		{
+			name: "numbered refactored source keeps its numbering",
+			text: "refactored_source_code:\n  file: |\n    1 package main\n    2 \n    3 func  main() {\n    4 x:=1\n    5 _ = x\n    6 }\n  replacements: []",
+			want: "refactored_source_code:\n  file: |\n    1 package main\n    2\n    3 func main() {\n    4 \tx := 1\n    5 \t_ = x\n    6 }\n  replacements: []",
+		},
+		{
+			name: "numbered block whose line count would change is left untouched",
+			text: "refactored_source_code:\n  file: |\n    1 package main\n    2 func a() {}; func b() {}\n",
+			want: "refactored_source_code:\n  file: |\n    1 package main\n    2 func a() {}; func b() {}\n",
+		},
+		{
+			name: "file block outside refactored_source_code is not Go code",
+			text: "config:\n  file: |\n    x:=1\n",
+			want: "config:\n  file: |\n    x:=1\n",
+		},
+		{
+			name: "invalid Go code is left untouched",
+			text: "test_code: |\n  func {\n",
+			want: "test_code: |\n  func {\n",
+		},
+		{
+			name: "trailing blank lines stay after the block",
+			text: "test_code: |\n  var  x = 1\n\n\nnext: value",
+			want: "test_code: |\n  var x = 1\n\n\nnext: value",
+		},
+		{
+			name:       "blocks gopls could not organize are marked when the source path is known",
+			text:       "test_code: |\n  var  x = 1\n",
+			sourcePath: "/src/pkg/client.go",
+			want:       "test_code: | " + importsNotOrganizedComment + "\n  var x = 1\n",
+		},
+	}
+
+	gc := &GeminiClient{logger: zap.NewNop()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gc.formatGeneratedCode(context.Background(), tt.text, tt.sourcePath); got != tt.want {
+				t.Errorf("formatGeneratedCode() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTestPathFor(t *testing.T) {
+	tests := []struct {
+		sourcePath string
+		want       string
+	}{
+		{sourcePath: "/src/pkg/client.go", want: "/src/pkg/client_test.go"},
+		{sourcePath: "/src/pkg/client_test.go", want: "/src/pkg/client_test.go"},
+		{sourcePath: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sourcePath, func(t *testing.T) {
+			if got := testPathFor(tt.sourcePath); got != tt.want {
+				t.Errorf("testPathFor(%q) = %q, want %q", tt.sourcePath, got, tt.want)
+			}
+		})
+	}
+}
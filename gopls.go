@@ -2,11 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go/build"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
@@ -16,22 +21,69 @@ import (
 	"gemini-tool/protocol"
 )
 
+const (
+	// callTimeout bounds requests made with a context that has no deadline
+	callTimeout = 30 * time.Second
+	// shutdownTimeout bounds the shutdown request sent when the client is closed
+	shutdownTimeout = 5 * time.Second
+)
+
 type GoplsClient struct {
-	cmd         *exec.Cmd
-	transport   *protocol.Transport
-	nextID      int64
-	closed      atomic.Bool
-	mutex       sync.Mutex
-	initialized bool
+	cmd              *exec.Cmd
+	remote           bool // connected to a shared gopls daemon, which outlives the client
+	exited           chan struct{}
+	transport        *protocol.Transport
+	nextID           int64
+	closed           atomic.Bool
+	mutex            sync.Mutex
+	initialized      atomic.Bool
+	rootDir          string
+	foldersMutex     sync.Mutex
+	workspaceFolders []string
+	capabilities     map[string]any
+	settings         map[string]any // gopls settings sent as initializationOptions and workspace configuration
+
+	documents *documentRegistry
+
+	commandMutex  sync.Mutex
+	editSinkMutex sync.Mutex
+	editSink      func(protocol.WorkspaceEdit) error // receives workspace/applyEdit edits of the running command
+
+	progressMutex   sync.Mutex
+	progress        map[string]*progressTask // work done progress tokens -> task in progress
+	progressSeen    bool                     // gopls began at least one task
+	lastProgress    time.Time
+	progressChanged chan struct{}
+	initializedAt   time.Time
+
+	diagnosticsMutex   sync.Mutex
+	diagnostics        map[string]*diagnosticsEntry
+	diagnosticsSeq     uint64
+	diagnosticsChanged chan struct{}
 }
 
-func NewGoplsClient() (*GoplsClient, error) {
-	goplsPath, err := exec.LookPath("gopls")
+// NewGoplsClient starts a gopls process for the workspace at rootDir, or connects to the
+// gopls daemon at config.Remote when it is set. ctx bounds starting gopls or connecting to
+// the daemon; the session outlives it.
+func NewGoplsClient(ctx context.Context, rootDir string, config GoplsConfig) (*GoplsClient, error) {
+	if config.Remote != "" {
+		return NewRemoteGoplsClient(ctx, rootDir, config)
+	}
+
+	// gopls is not started with exec.CommandContext, which would kill it along with ctx
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("gopls not started: %w", err)
+	}
+
+	rootDir, err := filepath.Abs(rootDir)
 	if err != nil {
-		return nil, fmt.Errorf("gopls is not installed or not in PATH: %w", err)
+		return nil, fmt.Errorf("invalid workspace root %q: %w", rootDir, err)
 	}
 
-	cmd := exec.Command(goplsPath, "serve", "-rpc.trace", "-logfile=auto")
+	cmd, err := config.command(rootDir)
+	if err != nil {
+		return nil, err
+	}
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -78,106 +130,167 @@ func NewGoplsClient() (*GoplsClient, error) {
 	bufferedStdout := bufio.NewReader(stdout)
 	bufferedStdin := bufio.NewWriter(stdin)
 
-	transport := protocol.NewTransport(bufferedStdout, bufferedStdin)
+	client = newGoplsClient(rootDir, protocol.NewTransport(bufferedStdout, bufferedStdin), config)
+	client.cmd = cmd
 
-	client = &GoplsClient{
-		cmd:         cmd,
-		transport:   transport,
-		nextID:      1,
-		initialized: false,
+	go func() {
+		err := cmd.Wait()
+		log.Printf("⚠️ gopls process exited: %v", err)
+		close(client.exited)
+	}()
+
+	log.Printf("✅ Gopls client created successfully (workspace root: %s)", rootDir)
+	return client, nil
+}
+
+// newGoplsClient creates a client speaking LSP over transport and starts reading from it
+func newGoplsClient(rootDir string, transport *protocol.Transport, config GoplsConfig) *GoplsClient {
+	client := &GoplsClient{
+		exited:           make(chan struct{}),
+		transport:        transport,
+		nextID:           1,
+		rootDir:          rootDir,
+		workspaceFolders: []string{rootDir},
+		capabilities:     map[string]any{},
+		settings:         config.initializationOptions(),
+		documents:        newDocumentRegistry(maxOpenDocuments),
+
+		progress:        make(map[string]*progressTask),
+		progressChanged: make(chan struct{}),
+
+		diagnostics:        make(map[string]*diagnosticsEntry),
+		diagnosticsChanged: make(chan struct{}),
 	}
 
-	client.closed.Store(false)
+	transport.SetDebug(config.Debug)
 
-	log.Printf("✅ Gopls client created successfully")
-	return client, nil
+	client.closed.Store(false)
+	client.registerHandlers()
+	transport.Start()
+	return client
 }
 
-func (c *GoplsClient) call(method string, params any) (*protocol.JSONRPCMessage, error) {
-	c.mutex.Lock()
+// call sends a request and waits for its response. A context without deadline gets
+// callTimeout. When ctx is done first, gopls is told to cancel the request and the
+// context error is returned.
+func (c *GoplsClient) call(ctx context.Context, method string, params any) (*protocol.JSONRPCMessage, error) {
 	log.Printf("⏳ Calling method: %s", method)
-	if c.closed.Load() {
-		c.mutex.Unlock()
+	if c.closed.Load() && method != "shutdown" {
 		log.Printf("❌ Client closed, cannot call %s", method)
 		return nil, fmt.Errorf("client closed")
 	}
 
-	if method != "initialize" && !c.initialized && method != "shutdown" {
-		c.mutex.Unlock()
+	if method != "initialize" && !c.initialized.Load() && method != "shutdown" {
 		log.Printf("❌ Client not initialized, cannot call %s", method)
 		return nil, fmt.Errorf("client not initialized")
 	}
 
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+
 	id := atomic.AddInt64(&c.nextID, 1)
 	req, err := protocol.NewRequest(id, method, params)
 	if err != nil {
-		c.mutex.Unlock()
 		log.Printf("❌ Error creating request: %v", err)
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	log.Println("✓ Request created")
 
+	responses := c.transport.Expect(id)
 	if err := c.transport.SendMessage(req); err != nil {
+		c.transport.Forget(id)
 		c.closed.Store(true)
-		c.mutex.Unlock()
 		log.Printf("❌ Error sending request: %v", err)
 		return nil, fmt.Errorf("failed to send request (client closed): %w", err)
 	}
-	c.mutex.Unlock()
 
-	startTime := time.Now()
-	maxWaitTime := 30 * time.Second
-	for time.Since(startTime) < maxWaitTime {
-		resp, err := c.transport.ReceiveMessage()
-		if err != nil {
-			if strings.Contains(err.Error(), "timeout") {
-				return nil, fmt.Errorf("timeout receiving response: %w", err)
-			}
-			c.closed.Store(true)
-			return nil, fmt.Errorf("failed to receive response (client closed): %w", err)
-		}
+	select {
+	case resp := <-responses:
+		// The transport already traces raw messages; results such as workspace symbols can be large
+		log.Printf("📥 %s response (id %d, %d bytes)", method, id, len(resp.Result))
 
-		var respID int64
-		switch v := resp.ID.(type) {
-		case float64:
-			respID = int64(v)
-		case int64:
-			respID = v
-		case json.Number:
-			respID64, err := v.Int64()
-			if err != nil {
-				log.Printf("⚠️ Invalid ID format in response: %v", resp.ID)
-				continue
-			}
-			respID = respID64
-		default:
-			log.Printf("⚠️ Unsupported ID type in response: %T", resp.ID)
-			continue
+		if resp.Error != nil {
+			return nil, fmt.Errorf("LSP error: %s (code: %d)", resp.Error.Message, resp.Error.Code)
 		}
 
-		if respID != id {
-			log.Printf("⚠️ Response ID (%v) does not match request ID (%d), ignored", resp.ID, id)
-			continue
-		}
+		return resp, nil
 
-		respBytes, _ := json.MarshalIndent(resp, "", "  ")
-		log.Printf("📥 Response content: %s", string(respBytes))
+	case <-c.transport.Done():
+		c.closed.Store(true)
+		return nil, fmt.Errorf("failed to receive response (client closed): %w", c.transport.Err())
 
-		if resp.Error != nil {
-			return nil, fmt.Errorf("LSP error: %s (code: %d)", resp.Error.Message, resp.Error.Code)
+	case <-ctx.Done():
+		c.transport.Forget(id)
+		c.cancelRequest(id)
+		log.Printf("🛑 %s cancelled: %v", method, ctx.Err())
+		return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+}
+
+// cancelRequest tells gopls a request is no longer wanted, so it stops working on it
+func (c *GoplsClient) cancelRequest(id int64) {
+	if err := c.notify("$/cancelRequest", protocol.CancelParams{ID: id}); err != nil {
+		log.Printf("⚠️ Failed to cancel request %d: %v", id, err)
+	}
+}
+
+func (c *GoplsClient) registerHandlers() {
+	c.transport.HandleRequest("workspace/configuration", func(msg *protocol.JSONRPCMessage) (any, error) {
+		var params struct {
+			Items []struct {
+				ScopeURI string `json:"scopeUri"`
+				Section  string `json:"section"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid configuration params: %w", err)
 		}
 
-		return resp, nil
+		// One entry per requested item; null keeps gopls defaults for other sections
+		results := make([]any, len(params.Items))
+		for i, item := range params.Items {
+			if item.Section == "gopls" {
+				results[i] = c.settings
+			}
+		}
+		return results, nil
+	})
+
+	acknowledge := func(msg *protocol.JSONRPCMessage) (any, error) {
+		return nil, nil
+	}
+	c.transport.HandleRequest("client/registerCapability", acknowledge)
+	c.transport.HandleRequest("client/unregisterCapability", acknowledge)
+	c.transport.HandleRequest("window/workDoneProgress/create", c.handleWorkDoneProgressCreate)
+	c.transport.HandleRequest("window/showMessageRequest", acknowledge)
+
+	logMessage := func(msg *protocol.JSONRPCMessage) {
+		var params struct {
+			Type    int    `json:"type"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			log.Printf("💬 gopls %s (type %d): %s", msg.Method, params.Type, params.Message)
+		}
 	}
+	c.transport.Subscribe("window/logMessage", logMessage)
+	c.transport.Subscribe("window/showMessage", logMessage)
 
-	return nil, fmt.Errorf("no response with matching ID after %v seconds", maxWaitTime.Seconds())
+	c.transport.Subscribe("$/progress", c.handleProgress)
+	c.transport.Subscribe("textDocument/publishDiagnostics", c.handlePublishDiagnostics)
+	c.transport.HandleRequest("workspace/applyEdit", c.handleApplyEdit)
 }
 
 func (c *GoplsClient) notify(method string, params any) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if c.closed.Load() {
+	if c.closed.Load() && method != "exit" {
 		return fmt.Errorf("client closed")
 	}
 
@@ -193,8 +306,8 @@ func (c *GoplsClient) notify(method string, params any) error {
 	return nil
 }
 
-func (c *GoplsClient) Initialize() error {
-	if c.initialized {
+func (c *GoplsClient) Initialize(ctx context.Context) error {
+	if c.initialized.Load() {
 		return nil
 	}
 	log.Println("Initializing LSP client...")
@@ -210,8 +323,15 @@ func (c *GoplsClient) Initialize() error {
 			"name":    "mcp-gopls",
 			"version": "1.0.0",
 		},
-		"rootUri": "file:///",
+		"rootUri": pathToURI(c.rootDir),
+		"workspaceFolders": []map[string]any{
+			workspaceFolder(c.rootDir),
+		},
+		"initializationOptions": c.settings,
 		"capabilities": map[string]any{
+			"window": map[string]any{
+				"workDoneProgress": true,
+			},
 			"textDocument": map[string]any{
 				"synchronization": map[string]any{
 					"dynamicRegistration": true,
@@ -222,7 +342,16 @@ func (c *GoplsClient) Initialize() error {
 				"completion": map[string]any{
 					"dynamicRegistration": true,
 					"completionItem": map[string]any{
-						"snippetSupport": true,
+						// Plain insert texts: the items are read by the model, not expanded by an editor
+						"snippetSupport":          false,
+						"documentationFormat":     []string{"markdown", "plaintext"},
+						"deprecatedSupport":       true,
+						"insertReplaceSupport":    false,
+						"labelDetailsSupport":     false,
+						"commitCharactersSupport": false,
+					},
+					"completionItemKind": map[string]any{
+						"valueSet": completionItemKindValueSet(),
 					},
 				},
 				"hover": map[string]any{
@@ -231,16 +360,38 @@ func (c *GoplsClient) Initialize() error {
 				},
 				"signatureHelp": map[string]any{
 					"dynamicRegistration": true,
+					"signatureInformation": map[string]any{
+						"documentationFormat":    []string{"markdown", "plaintext"},
+						"activeParameterSupport": true,
+						"parameterInformation": map[string]any{
+							"labelOffsetSupport": true,
+						},
+					},
 				},
 				"definition": map[string]any{
 					"dynamicRegistration": true,
 				},
+				"typeDefinition": map[string]any{
+					"dynamicRegistration": true,
+					"linkSupport":         false,
+				},
 				"references": map[string]any{
 					"dynamicRegistration": true,
 				},
-				"documentSymbol": map[string]any{
+				"implementation": map[string]any{
+					"dynamicRegistration": true,
+					"linkSupport":         false,
+				},
+				"callHierarchy": map[string]any{
 					"dynamicRegistration": true,
 				},
+				"documentSymbol": map[string]any{
+					"dynamicRegistration":               true,
+					"hierarchicalDocumentSymbolSupport": true,
+					"symbolKind": map[string]any{
+						"valueSet": symbolKindValueSet(),
+					},
+				},
 				"formatting": map[string]any{
 					"dynamicRegistration": true,
 				},
@@ -249,15 +400,48 @@ func (c *GoplsClient) Initialize() error {
 				},
 				"publishDiagnostics": map[string]any{
 					"relatedInformation": true,
+					"versionSupport":     true,
+				},
+				"diagnostic": map[string]any{
+					"dynamicRegistration": true,
+				},
+				"rename": map[string]any{
+					"dynamicRegistration": true,
+				},
+				"codeAction": map[string]any{
+					"dynamicRegistration": true,
+					"isPreferredSupport":  true,
+					"codeActionLiteralSupport": map[string]any{
+						"codeActionKind": map[string]any{
+							"valueSet": []string{
+								"quickfix", "refactor", "refactor.extract", "refactor.inline",
+								"refactor.rewrite", "source", "source.organizeImports", "source.fixAll",
+							},
+						},
+					},
 				},
 			},
 			"workspace": map[string]any{
-				"applyEdit": true,
+				"applyEdit":        true,
+				"workspaceFolders": true,
+				"workspaceEdit": map[string]any{
+					"documentChanges":    true,
+					"resourceOperations": []string{"create", "rename", "delete"},
+				},
+				"executeCommand": map[string]any{
+					"dynamicRegistration": true,
+				},
+				"didChangeWatchedFiles": map[string]any{
+					"dynamicRegistration": true,
+				},
 				"didChangeConfiguration": map[string]any{
 					"dynamicRegistration": true,
 				},
 				"symbol": map[string]any{
 					"dynamicRegistration": true,
+					"symbolKind": map[string]any{
+						"valueSet": symbolKindValueSet(),
+					},
 				},
 			},
 		},
@@ -265,17 +449,23 @@ func (c *GoplsClient) Initialize() error {
 	}
 
 	var err error
+	var resp *protocol.JSONRPCMessage
 	for attempt := 1; attempt <= 3; attempt++ {
 		log.Printf("Initialization attempt %d/3", attempt)
-		_, err = c.call("initialize", initParams)
+		resp, err = c.call(ctx, "initialize", initParams)
 		if err == nil {
 			break
 		}
 
-		if strings.Contains(err.Error(), "timeout") {
+		// Only the per-call timeout is retried, not a deadline or cancellation of the caller
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
 			log.Printf("Timeout during initialization (attempt %d): %v", attempt, err)
 			if attempt < 3 {
-				time.Sleep(500 * time.Millisecond)
+				select {
+				case <-time.After(500 * time.Millisecond):
+				case <-ctx.Done():
+					return fmt.Errorf("failed to initialize: %w", ctx.Err())
+				}
 				continue
 			}
 		} else {
@@ -288,12 +478,25 @@ func (c *GoplsClient) Initialize() error {
 	}
 
 	log.Println("Initialization succeeded")
-	c.initialized = true
+
+	var initResult struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	if err := resp.ParseResult(&initResult); err != nil {
+		log.Printf("⚠️ Unable to decode server capabilities: %v", err)
+	} else if initResult.Capabilities != nil {
+		c.capabilities = initResult.Capabilities
+	}
+	c.progressMutex.Lock()
+	c.initializedAt = time.Now()
+	c.progressMutex.Unlock()
+
+	c.initialized.Store(true)
 	log.Println("LSP client initialized")
 
 	initNotif := map[string]any{}
 	if err := c.notify("initialized", initNotif); err != nil {
-		c.initialized = false
+		c.initialized.Store(false)
 		return fmt.Errorf("failed to send notification 'initialized': %w", err)
 	}
 	log.Println("Notification 'initialized' sent")
@@ -301,8 +504,8 @@ func (c *GoplsClient) Initialize() error {
 	return nil
 }
 
-func (c *GoplsClient) Shutdown() error {
-	_, err := c.call("shutdown", nil)
+func (c *GoplsClient) Shutdown(ctx context.Context) error {
+	_, err := c.call(ctx, "shutdown", nil)
 	if err != nil {
 		return fmt.Errorf("failed to shutdown: %w", err)
 	}
@@ -317,16 +520,24 @@ func (c *GoplsClient) Close() error {
 
 	var errs []error
 
-	if c.initialized {
-		if err := c.Shutdown(); err != nil {
-			errs = append(errs, fmt.Errorf("error during shutdown: %w", err))
-		}
+	// The session of a shared daemon ends with its connection; shutdown and exit would be
+	// meant for the daemon itself
+	if c.initialized.Load() && !c.remote {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
 
-		if err := c.notify("exit", nil); err != nil {
+		// exit is only sent once gopls acknowledged the shutdown; otherwise the process is killed below
+		if err := c.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error during shutdown: %w", err))
+		} else if err := c.notify("exit", nil); err != nil {
 			errs = append(errs, fmt.Errorf("error sending exit notification: %w", err))
 		}
-		c.initialized = false
 	}
+	c.initialized.Store(false)
+
+	c.transport.Close()
+	// The tasks of this session will never end; a restarted session starts from a clean slate
+	c.resetProgress()
 
 	if c.cmd != nil && c.cmd.Process != nil {
 		if err := c.cmd.Process.Kill(); err != nil {
@@ -341,7 +552,77 @@ func (c *GoplsClient) Close() error {
 	return nil
 }
 
-func (c *GoplsClient) GoToDefinition(uri string, line, character int) ([]protocol.Location, error) {
+func (c *GoplsClient) RootDir() string {
+	return c.rootDir
+}
+
+func (c *GoplsClient) HasWorkspaceFolder(dir string) bool {
+	c.foldersMutex.Lock()
+	defer c.foldersMutex.Unlock()
+
+	for _, folder := range c.workspaceFolders {
+		if isWithinDir(dir, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *GoplsClient) AddWorkspaceFolder(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("invalid workspace folder %q: %w", dir, err)
+	}
+
+	if c.HasWorkspaceFolder(dir) {
+		return nil
+	}
+
+	log.Printf("📁 Adding workspace folder: %s", dir)
+
+	params := map[string]any{
+		"event": map[string]any{
+			"added":   []map[string]any{workspaceFolder(dir)},
+			"removed": []map[string]any{},
+		},
+	}
+
+	if err := c.notify("workspace/didChangeWorkspaceFolders", params); err != nil {
+		return fmt.Errorf("failed to add workspace folder: %w", err)
+	}
+
+	c.foldersMutex.Lock()
+	c.workspaceFolders = append(c.workspaceFolders, dir)
+	c.foldersMutex.Unlock()
+
+	return nil
+}
+
+func (c *GoplsClient) HasCapability(name string) bool {
+	value, ok := c.capabilities[name]
+	if !ok || value == nil {
+		return false
+	}
+	if enabled, ok := value.(bool); ok {
+		return enabled
+	}
+	return true
+}
+
+func (c *GoplsClient) IsAlive() bool {
+	if c.closed.Load() || c.transport.IsClosed() {
+		return false
+	}
+
+	select {
+	case <-c.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *GoplsClient) GoToDefinition(ctx context.Context, uri string, line, character int) ([]protocol.Location, error) {
 	params := protocol.TextDocumentPositionParams{
 		TextDocument: protocol.TextDocumentIdentifier{
 			URI: uri,
@@ -352,7 +633,7 @@ func (c *GoplsClient) GoToDefinition(uri string, line, character int) ([]protoco
 		},
 	}
 
-	resp, err := c.call("textDocument/definition", params)
+	resp, err := c.call(ctx, "textDocument/definition", params)
 	if err != nil {
 		return nil, err
 	}
@@ -365,7 +646,31 @@ func (c *GoplsClient) GoToDefinition(uri string, line, character int) ([]protoco
 	return locations, nil
 }
 
-func (c *GoplsClient) FindReferences(uri string, line, character int, includeDeclaration bool) ([]protocol.Location, error) {
+func (c *GoplsClient) GoToTypeDefinition(ctx context.Context, uri string, line, character int) ([]protocol.Location, error) {
+	params := protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		Position: protocol.Position{
+			Line:      line,
+			Character: character,
+		},
+	}
+
+	resp, err := c.call(ctx, "textDocument/typeDefinition", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []protocol.Location
+	if err := resp.ParseResult(&locations); err != nil {
+		return nil, fmt.Errorf("failed to decode type definition results: %w", err)
+	}
+
+	return locations, nil
+}
+
+func (c *GoplsClient) FindReferences(ctx context.Context, uri string, line, character int, includeDeclaration bool) ([]protocol.Location, error) {
 	params := protocol.ReferenceParams{
 		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
 			TextDocument: protocol.TextDocumentIdentifier{
@@ -381,7 +686,7 @@ func (c *GoplsClient) FindReferences(uri string, line, character int, includeDec
 		},
 	}
 
-	resp, err := c.call("textDocument/references", params)
+	resp, err := c.call(ctx, "textDocument/references", params)
 	if err != nil {
 		return nil, err
 	}
@@ -394,72 +699,127 @@ func (c *GoplsClient) FindReferences(uri string, line, character int, includeDec
 	return locations, nil
 }
 
-func (c *GoplsClient) GetDiagnostics(uri string) ([]protocol.Diagnostic, error) {
-	if err := c.DidOpen(uri, "go", ""); err != nil {
+func (c *GoplsClient) FindImplementations(ctx context.Context, uri string, line, character int) ([]protocol.Location, error) {
+	params := protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		Position: protocol.Position{
+			Line:      line,
+			Character: character,
+		},
+	}
+
+	resp, err := c.call(ctx, "textDocument/implementation", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []protocol.Location
+	if err := resp.ParseResult(&locations); err != nil {
+		return nil, fmt.Errorf("failed to decode implementation results: %w", err)
+	}
+
+	return locations, nil
+}
+
+func (c *GoplsClient) PrepareCallHierarchy(ctx context.Context, uri string, line, character int) ([]protocol.CallHierarchyItem, error) {
+	params := protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		Position: protocol.Position{
+			Line:      line,
+			Character: character,
+		},
+	}
+
+	resp, err := c.call(ctx, "textDocument/prepareCallHierarchy", params)
+	if err != nil {
 		return nil, err
 	}
 
-	return []protocol.Diagnostic{}, nil
+	var items []protocol.CallHierarchyItem
+	if err := resp.ParseResult(&items); err != nil {
+		return nil, fmt.Errorf("failed to decode call hierarchy items: %w", err)
+	}
+
+	return items, nil
 }
 
-func (c *GoplsClient) DidOpen(uri, languageID, text string) error {
-	log.Printf("📝 Opening document: %s", uri)
+func (c *GoplsClient) IncomingCalls(ctx context.Context, item protocol.CallHierarchyItem) ([]protocol.CallHierarchyIncomingCall, error) {
+	resp, err := c.call(ctx, "callHierarchy/incomingCalls", protocol.CallHierarchyCallsParams{Item: item})
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []protocol.CallHierarchyIncomingCall
+	if err := resp.ParseResult(&calls); err != nil {
+		return nil, fmt.Errorf("failed to decode incoming calls: %w", err)
+	}
 
-	if text == "" {
-		filePath := strings.TrimPrefix(uri, "file://")
-		if runtime.GOOS == "windows" {
-			filePath = strings.TrimPrefix(filePath, "/")
-			filePath = strings.ReplaceAll(filePath, "/", "\\")
-		}
+	return calls, nil
+}
 
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			log.Printf("⚠️ Unable to read file content: %v", err)
-			text = ""
-		} else {
-			text = string(content)
-			log.Printf("✓ File read successfully (%d bytes)", len(text))
-		}
+func (c *GoplsClient) OutgoingCalls(ctx context.Context, item protocol.CallHierarchyItem) ([]protocol.CallHierarchyOutgoingCall, error) {
+	resp, err := c.call(ctx, "callHierarchy/outgoingCalls", protocol.CallHierarchyCallsParams{Item: item})
+	if err != nil {
+		return nil, err
 	}
 
-	params := map[string]any{
-		"textDocument": map[string]any{
-			"uri":        uri,
-			"languageId": languageID,
-			"version":    1,
-			"text":       text,
-		},
+	var calls []protocol.CallHierarchyOutgoingCall
+	if err := resp.ParseResult(&calls); err != nil {
+		return nil, fmt.Errorf("failed to decode outgoing calls: %w", err)
 	}
 
-	err := c.notify("textDocument/didOpen", params)
+	return calls, nil
+}
+
+func (c *GoplsClient) WorkspaceSymbols(ctx context.Context, query string) ([]protocol.SymbolInformation, error) {
+	resp, err := c.call(ctx, "workspace/symbol", protocol.WorkspaceSymbolParams{Query: query})
 	if err != nil {
-		log.Printf("❌ Error opening document: %v", err)
-		return fmt.Errorf("failed to open document: %w", err)
+		return nil, err
 	}
 
-	log.Printf("✓ Document opened successfully: %s", uri)
-	return nil
+	var symbols []protocol.SymbolInformation
+	if err := resp.ParseResult(&symbols); err != nil {
+		return nil, fmt.Errorf("failed to decode workspace symbols: %w", err)
+	}
+
+	return symbols, nil
 }
 
-func (c *GoplsClient) DidClose(uri string) error {
-	params := map[string]any{
-		"textDocument": map[string]any{
-			"uri": uri,
+func (c *GoplsClient) DocumentSymbols(ctx context.Context, uri string) ([]protocol.DocumentSymbol, error) {
+	if err := c.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
+	}
+
+	params := protocol.DocumentSymbolParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
 		},
 	}
 
-	return c.notify("textDocument/didClose", params)
+	resp, err := c.call(ctx, "textDocument/documentSymbol", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var symbols []protocol.DocumentSymbol
+	if err := resp.ParseResult(&symbols); err != nil {
+		return nil, fmt.Errorf("failed to decode document symbols: %w", err)
+	}
+
+	return symbols, nil
 }
 
-func (c *GoplsClient) GetHover(uri string, line, character int) (string, error) {
+func (c *GoplsClient) GetHover(ctx context.Context, uri string, line, character int) (*protocol.Hover, error) {
 	log.Printf("🔍 Requesting hover information for %s position L%d:C%d", uri, line, character)
 
-	if err := c.DidOpen(uri, "go", ""); err != nil {
-		log.Printf("⚠️ Warning opening document: %v", err)
+	if err := c.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
 	}
 
-	time.Sleep(100 * time.Millisecond)
-
 	params := protocol.TextDocumentPositionParams{
 		TextDocument: protocol.TextDocumentIdentifier{
 			URI: uri,
@@ -470,66 +830,72 @@ func (c *GoplsClient) GetHover(uri string, line, character int) (string, error)
 		},
 	}
 
-	resp, err := c.call("textDocument/hover", params)
+	resp, err := c.call(ctx, "textDocument/hover", params)
 	if err != nil {
-		return "", fmt.Errorf("failed to request hover: %w", err)
+		return nil, fmt.Errorf("failed to request hover: %w", err)
 	}
 
-	if resp == nil {
-		return "", fmt.Errorf("no response received for hover")
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return nil, fmt.Errorf("no hover information available for this position")
 	}
 
-	if len(resp.Result) == 0 || string(resp.Result) == "null" {
-		return "", fmt.Errorf("no hover information available for this position")
+	var hover protocol.Hover
+	if err := resp.ParseResult(&hover); err != nil {
+		return nil, fmt.Errorf("failed to decode hover result: %w", err)
 	}
 
-	var result map[string]any
-	if err := resp.ParseResult(&result); err != nil {
-		return "", fmt.Errorf("failed to decode hover result: %w", err)
+	if strings.TrimSpace(hover.Contents.Value) == "" {
+		return nil, fmt.Errorf("no hover information available for this position")
 	}
 
-	log.Printf("📋 Decoded hover response: %+v", result)
+	return &hover, nil
+}
 
-	if len(result) == 0 {
-		return "", fmt.Errorf("no hover information available for this position")
+func (c *GoplsClient) GetCompletion(ctx context.Context, uri string, line, character int) (*protocol.CompletionList, error) {
+	if err := c.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
 	}
 
-	if contents, ok := result["contents"].(map[string]any); ok {
-		if value, ok := contents["value"].(string); ok {
-			return value, nil
-		}
-		if kind, ok := contents["kind"].(string); ok && kind == "markdown" {
-			if value, ok := contents["value"].(string); ok {
-				return value, nil
-			}
-		}
+	params := protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		Position: protocol.Position{
+			Line:      line,
+			Character: character,
+		},
 	}
 
-	if contents, ok := result["contents"].(string); ok {
-		return contents, nil
+	resp, err := c.call(ctx, "textDocument/completion", params)
+	if err != nil {
+		return nil, err
 	}
 
-	if contentsArray, ok := result["contents"].([]any); ok && len(contentsArray) > 0 {
-		if firstItem, ok := contentsArray[0].(map[string]any); ok {
-			if value, ok := firstItem["value"].(string); ok {
-				return value, nil
-			}
-		}
+	completions := &protocol.CompletionList{Items: []protocol.CompletionItem{}}
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return completions, nil
 	}
 
-	data, err := json.Marshal(result)
-	if err != nil {
-		return "", err
+	// The result is either a CompletionList or a bare CompletionItem array
+	if resp.Result[0] == '[' {
+		if err := resp.ParseResult(&completions.Items); err != nil {
+			return nil, fmt.Errorf("failed to decode completion items: %w", err)
+		}
+		return completions, nil
 	}
 
-	if len(data) == 2 && string(data) == "{}" {
-		return "", fmt.Errorf("no hover information available for this position")
+	if err := resp.ParseResult(completions); err != nil {
+		return nil, fmt.Errorf("failed to decode completion result: %w", err)
 	}
 
-	return string(data), nil
+	return completions, nil
 }
 
-func (c *GoplsClient) GetCompletion(uri string, line, character int) ([]string, error) {
+func (c *GoplsClient) SignatureHelp(ctx context.Context, uri string, line, character int) (*protocol.SignatureHelp, error) {
+	if err := c.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
+	}
+
 	params := protocol.TextDocumentPositionParams{
 		TextDocument: protocol.TextDocumentIdentifier{
 			URI: uri,
@@ -540,26 +906,163 @@ func (c *GoplsClient) GetCompletion(uri string, line, character int) ([]string,
 		},
 	}
 
-	resp, err := c.call("textDocument/completion", params)
+	resp, err := c.call(ctx, "textDocument/signatureHelp", params)
 	if err != nil {
 		return nil, err
 	}
 
-	var result map[string]any
-	if err := resp.ParseResult(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode completion result: %w", err)
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return nil, fmt.Errorf("no signature help available: the position is not inside a call")
 	}
 
-	var completions []string
-	if items, ok := result["items"].([]any); ok {
-		for _, item := range items {
-			if itemMap, ok := item.(map[string]any); ok {
-				if label, ok := itemMap["label"].(string); ok {
-					completions = append(completions, label)
-				}
+	var help protocol.SignatureHelp
+	if err := resp.ParseResult(&help); err != nil {
+		return nil, fmt.Errorf("failed to decode signature help: %w", err)
+	}
+
+	return &help, nil
+}
+
+// FindWorkspaceRoot returns the directory gopls should use as workspace for path.
+// It walks up from path and prefers the directory of an enclosing go.work, then the
+// nearest go.mod, and falls back to the directory of path itself.
+func FindWorkspaceRoot(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+
+	dir := absPath
+	if info, err := os.Stat(absPath); err != nil || !info.IsDir() {
+		dir = filepath.Dir(absPath)
+	}
+
+	moduleRoot := ""
+	for current := dir; ; current = filepath.Dir(current) {
+		if fileExists(filepath.Join(current, "go.work")) {
+			return current
+		}
+		if moduleRoot == "" && fileExists(filepath.Join(current, "go.mod")) {
+			moduleRoot = current
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+	}
+
+	if moduleRoot != "" {
+		return moduleRoot
+	}
+	return dir
+}
+
+// symbolKindValueSet lists every LSP symbol kind so the server does not fall back to
+// the kinds of the first protocol version
+func symbolKindValueSet() []protocol.SymbolKind {
+	kinds := make([]protocol.SymbolKind, 0, 26)
+	for kind := protocol.SymbolKind(1); kind <= 26; kind++ {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+func completionItemKindValueSet() []protocol.CompletionItemKind {
+	kinds := make([]protocol.CompletionItemKind, 0, 25)
+	for kind := protocol.CompletionItemKind(1); kind <= 25; kind++ {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// importPath returns the import path of the package in dir, derived from the enclosing
+// go.mod or, for the standard library, from GOROOT
+func importPath(dir string) string {
+	goroot := filepath.Join(build.Default.GOROOT, "src")
+	if build.Default.GOROOT != "" && isWithinDir(dir, goroot) {
+		rel, _ := filepath.Rel(goroot, dir)
+		return filepath.ToSlash(rel)
+	}
+
+	for current := dir; ; current = filepath.Dir(current) {
+		if content, err := os.ReadFile(filepath.Join(current, "go.mod")); err == nil {
+			modulePath := modulePathFromGoMod(content)
+			if modulePath == "" {
+				return ""
 			}
+			rel, err := filepath.Rel(current, dir)
+			if err != nil || rel == "." {
+				return modulePath
+			}
+			return modulePath + "/" + filepath.ToSlash(rel)
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return ""
+		}
+	}
+}
+
+func modulePathFromGoMod(content []byte) string {
+	for _, line := range strings.Split(string(content), "\n") {
+		if modulePath, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+			return strings.Trim(strings.TrimSpace(modulePath), `"`)
 		}
 	}
+	return ""
+}
 
-	return completions, nil
+func isStandardLibrary(path string) bool {
+	return build.Default.GOROOT != "" && isWithinDir(path, filepath.Join(build.Default.GOROOT, "src"))
+}
+
+func pathToURI(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+
+	slashed := filepath.ToSlash(absPath)
+	if !strings.HasPrefix(slashed, "/") {
+		// Windows drive letter paths
+		slashed = "/" + slashed
+	}
+
+	return (&url.URL{Scheme: "file", Path: slashed}).String()
+}
+
+func uriToPath(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return strings.TrimPrefix(uri, "file://")
+	}
+
+	path := parsed.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+
+	return filepath.FromSlash(path)
+}
+
+func workspaceFolder(dir string) map[string]any {
+	return map[string]any{
+		"uri":  pathToURI(dir),
+		"name": filepath.Base(dir),
+	}
+}
+
+func isWithinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// defaultGoplsArgs are the arguments gopls is started with when GoplsConfig.Args is empty
+var defaultGoplsArgs = []string{"serve", "-rpc.trace", "-logfile=auto"}
+
+// GoplsConfig configures how gopls is launched and the settings it runs with
+type GoplsConfig struct {
+	// Path is the gopls binary. When empty, gopls is looked up in PATH.
+	Path string
+	// Args are the command line arguments, replacing the default "serve -rpc.trace -logfile=auto"
+	Args []string
+	// Env holds variables added to the inherited environment, e.g. GOFLAGS, GOOS, GOARCH,
+	// GOWORK or GOPRIVATE
+	Env map[string]string
+	// Settings are gopls settings such as buildFlags, directoryFilters or staticcheck. They
+	// are sent as initializationOptions and returned for the "gopls" configuration section.
+	Settings map[string]any
+	// Remote is the address of a shared gopls daemon, "unix;/path" or "tcp:host:port". When set,
+	// the client connects to it instead of starting gopls, and Path, Args and Env are ignored.
+	Remote string
+	// Reconnect controls how connections to Remote are retried; DefaultReconnectPolicy when empty
+	Reconnect ReconnectPolicy
+	// Debug logs the body of every message received from gopls, not only its method and size
+	Debug bool
+}
+
+// GoplsConfigFromEnv reads the gopls configuration from the environment:
+//   - GOPLS_PATH: the gopls binary
+//   - GOPLS_ARGS: space separated command line arguments
+//   - GOPLS_ENV: JSON object of environment variables, e.g. {"GOFLAGS": "-mod=mod"}
+//   - GOPLS_SETTINGS: JSON object of gopls settings, e.g. {"buildFlags": ["-tags=integration"]}
+//   - GOPLS_REMOTE: address of a shared gopls daemon, e.g. unix;/tmp/gopls.sock
+//   - GOPLS_DEBUG: any non-empty value logs the body of every message received from gopls
+func GoplsConfigFromEnv() (GoplsConfig, error) {
+	config := GoplsConfig{
+		Path:   os.Getenv("GOPLS_PATH"),
+		Args:   strings.Fields(os.Getenv("GOPLS_ARGS")),
+		Remote: os.Getenv("GOPLS_REMOTE"),
+		Debug:  os.Getenv("GOPLS_DEBUG") != "",
+	}
+
+	if env := os.Getenv("GOPLS_ENV"); env != "" {
+		if err := json.Unmarshal([]byte(env), &config.Env); err != nil {
+			return GoplsConfig{}, fmt.Errorf("invalid GOPLS_ENV, expected a JSON object of strings: %w", err)
+		}
+	}
+
+	if settings := os.Getenv("GOPLS_SETTINGS"); settings != "" {
+		if err := json.Unmarshal([]byte(settings), &config.Settings); err != nil {
+			return GoplsConfig{}, fmt.Errorf("invalid GOPLS_SETTINGS, expected a JSON object: %w", err)
+		}
+	}
+
+	return config, nil
+}
+
+// command builds the gopls command run in dir
+func (config GoplsConfig) command(dir string) (*exec.Cmd, error) {
+	path := config.Path
+	if path == "" {
+		path = "gopls"
+	}
+
+	goplsPath, err := exec.LookPath(path)
+	if err != nil {
+		if config.Path == "" {
+			return nil, fmt.Errorf("gopls is not installed or not in PATH: %w", err)
+		}
+		return nil, fmt.Errorf("gopls binary %q not found: %w", config.Path, err)
+	}
+
+	args := config.Args
+	if len(args) == 0 {
+		args = defaultGoplsArgs
+	}
+
+	cmd := exec.Command(goplsPath, args...)
+	cmd.Dir = dir
+
+	if len(config.Env) > 0 {
+		// Later entries win, so the configured variables override the inherited ones
+		cmd.Env = os.Environ()
+		for _, name := range slices.Sorted(maps.Keys(config.Env)) {
+			cmd.Env = append(cmd.Env, name+"="+config.Env[name])
+		}
+	}
+
+	return cmd, nil
+}
+
+// reconnectPolicy returns the policy for connections to Remote, filling in defaults
+func (config GoplsConfig) reconnectPolicy() ReconnectPolicy {
+	policy := config.Reconnect
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = DefaultReconnectPolicy.MaxAttempts
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultReconnectPolicy.InitialBackoff
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = max(DefaultReconnectPolicy.MaxBackoff, policy.InitialBackoff)
+	}
+	return policy
+}
+
+// initializationOptions returns the gopls settings sent with initialize. Diagnostic passes
+// are reported as work done progress unless the settings say otherwise, so WaitForIdle covers them.
+func (config GoplsConfig) initializationOptions() map[string]any {
+	options := map[string]any{
+		"verboseWorkDoneProgress": true,
+	}
+	maps.Copy(options, config.Settings)
+	return options
+}
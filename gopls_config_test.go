@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGoplsConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    GoplsConfig
+		wantErr bool
+	}{
+		{
+			name: "nothing set",
+			want: GoplsConfig{Args: []string{}},
+		},
+		{
+			name: "every variable",
+			env: map[string]string{
+				"GOPLS_PATH":     "/opt/gopls",
+				"GOPLS_ARGS":     "serve  -rpc.trace",
+				"GOPLS_ENV":      `{"GOFLAGS": "-mod=mod"}`,
+				"GOPLS_SETTINGS": `{"buildFlags": ["-tags=integration"], "staticcheck": true}`,
+				"GOPLS_REMOTE":   "unix;/tmp/gopls.sock",
+				"GOPLS_DEBUG":    "1",
+			},
+			want: GoplsConfig{
+				Path:     "/opt/gopls",
+				Args:     []string{"serve", "-rpc.trace"},
+				Env:      map[string]string{"GOFLAGS": "-mod=mod"},
+				Settings: map[string]any{"buildFlags": []any{"-tags=integration"}, "staticcheck": true},
+				Remote:   "unix;/tmp/gopls.sock",
+				Debug:    true,
+			},
+		},
+		{
+			name:    "environment is not an object of strings",
+			env:     map[string]string{"GOPLS_ENV": `{"CGO_ENABLED": 0}`},
+			wantErr: true,
+		},
+		{
+			name:    "settings are not JSON",
+			env:     map[string]string{"GOPLS_SETTINGS": "buildFlags=-tags=integration"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"GOPLS_PATH", "GOPLS_ARGS", "GOPLS_ENV", "GOPLS_SETTINGS", "GOPLS_REMOTE", "GOPLS_DEBUG"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			got, err := GoplsConfigFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GoplsConfigFromEnv() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GoplsConfigFromEnv() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GoplsConfigFromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoplsConfigCommand(t *testing.T) {
+	path, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no binary to stand in for gopls")
+	}
+
+	tests := []struct {
+		name     string
+		config   GoplsConfig
+		wantArgs []string
+		wantEnv  []string // expected at the end of the environment, nil for the inherited one
+	}{
+		{
+			name:     "default arguments",
+			config:   GoplsConfig{Path: path},
+			wantArgs: defaultGoplsArgs,
+		},
+		{
+			name:     "custom arguments and sorted environment",
+			config:   GoplsConfig{Path: path, Args: []string{"serve"}, Env: map[string]string{"GOOS": "linux", "GOFLAGS": "-mod=mod"}},
+			wantArgs: []string{"serve"},
+			wantEnv:  []string{"GOFLAGS=-mod=mod", "GOOS=linux"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := tt.config.command("/workspace")
+			if err != nil {
+				t.Fatalf("command() failed: %v", err)
+			}
+			if !slices.Equal(cmd.Args[1:], tt.wantArgs) || cmd.Dir != "/workspace" {
+				t.Errorf("command() runs %q in %s, want %q in /workspace", cmd.Args[1:], cmd.Dir, tt.wantArgs)
+			}
+			if tt.wantEnv == nil {
+				if cmd.Env != nil {
+					t.Errorf("command() environment = %q, want the inherited one", cmd.Env)
+				}
+			} else if len(cmd.Env) < len(tt.wantEnv) || !slices.Equal(cmd.Env[len(cmd.Env)-len(tt.wantEnv):], tt.wantEnv) {
+				t.Errorf("command() environment ends with %q, want %q", cmd.Env[max(len(cmd.Env)-len(tt.wantEnv), 0):], tt.wantEnv)
+			}
+		})
+	}
+
+	if _, err := (GoplsConfig{Path: "/nonexistent/gopls"}).command("/workspace"); err == nil {
+		t.Error("command() with a missing binary succeeded, want an error")
+	}
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ReconnectPolicy
+		want   ReconnectPolicy
+	}{
+		{name: "empty policy uses the defaults", want: DefaultReconnectPolicy},
+		{
+			name:   "configured policy is kept",
+			policy: ReconnectPolicy{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second},
+			want:   ReconnectPolicy{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second},
+		},
+		{
+			name:   "maximum backoff is at least the initial one",
+			policy: ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Second},
+			want:   ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Second, MaxBackoff: 10 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (GoplsConfig{Reconnect: tt.policy}).reconnectPolicy(); got != tt.want {
+				t.Errorf("reconnectPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitializationOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]any
+		want     map[string]any
+	}{
+		{
+			name: "progress is verbose by default",
+			want: map[string]any{"verboseWorkDoneProgress": true},
+		},
+		{
+			name:     "settings are added and can override the default",
+			settings: map[string]any{"buildFlags": []string{"-tags=integration"}, "verboseWorkDoneProgress": false},
+			want:     map[string]any{"buildFlags": []string{"-tags=integration"}, "verboseWorkDoneProgress": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (GoplsConfig{Settings: tt.settings}).initializationOptions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("initializationOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gemini-tool/protocol"
+)
+
+const (
+	// diagnosticsSettleTime is how long no new publishDiagnostics may arrive for a document
+	// before its diagnostic pass is considered finished
+	diagnosticsSettleTime = 500 * time.Millisecond
+	// diagnosticsStaleGrace is how long to wait for a fresh publish before returning
+	// diagnostics that were published earlier for the same document
+	diagnosticsStaleGrace = 2 * time.Second
+	diagnosticsTimeout    = 15 * time.Second
+)
+
+type diagnosticsEntry struct {
+	seq         uint64
+	version     *int
+	diagnostics []protocol.Diagnostic
+	received    time.Time
+}
+
+func (c *GoplsClient) handlePublishDiagnostics(msg *protocol.JSONRPCMessage) {
+	var params protocol.PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		log.Printf("⚠️ Invalid publishDiagnostics params: %v", err)
+		return
+	}
+
+	c.diagnosticsMutex.Lock()
+	defer c.diagnosticsMutex.Unlock()
+
+	c.diagnosticsSeq++
+	c.diagnostics[params.URI] = &diagnosticsEntry{
+		seq:         c.diagnosticsSeq,
+		version:     params.Version,
+		diagnostics: params.Diagnostics,
+		received:    time.Now(),
+	}
+
+	// Wake up every waiter and arm a new channel for the next publish
+	close(c.diagnosticsChanged)
+	c.diagnosticsChanged = make(chan struct{})
+
+	log.Printf("🩺 %d diagnostics published for %s", len(params.Diagnostics), params.URI)
+}
+
+// GetDiagnostics returns the diagnostics of a document opened with text, or with the file
+// content when text is empty
+func (c *GoplsClient) GetDiagnostics(ctx context.Context, uri, text string) ([]protocol.Diagnostic, error) {
+	if c.HasCapability("diagnosticProvider") {
+		diagnostics, err := c.pullDiagnostics(ctx, uri, text)
+		if err == nil {
+			return diagnostics, nil
+		}
+		log.Printf("⚠️ Pull diagnostics failed, falling back to published diagnostics: %v", err)
+	}
+
+	c.diagnosticsMutex.Lock()
+	since := c.diagnosticsSeq
+	c.diagnosticsMutex.Unlock()
+
+	if err := c.DidOpen(ctx, uri, "go", text); err != nil {
+		return nil, err
+	}
+
+	return c.waitForDiagnostics(ctx, uri, since)
+}
+
+// cachedDiagnostics returns the diagnostics last published for uri without waiting for new ones
+func (c *GoplsClient) cachedDiagnostics(uri string) []protocol.Diagnostic {
+	c.diagnosticsMutex.Lock()
+	defer c.diagnosticsMutex.Unlock()
+
+	if entry := c.diagnostics[uri]; entry != nil {
+		return entry.diagnostics
+	}
+	return nil
+}
+
+func (c *GoplsClient) pullDiagnostics(ctx context.Context, uri, text string) ([]protocol.Diagnostic, error) {
+	if err := c.DidOpen(ctx, uri, "go", text); err != nil {
+		return nil, err
+	}
+
+	params := protocol.DocumentDiagnosticParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+	}
+
+	resp, err := c.call(ctx, "textDocument/diagnostic", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var report protocol.DocumentDiagnosticReport
+	if err := resp.ParseResult(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode diagnostic report: %w", err)
+	}
+
+	if report.Kind != "full" {
+		return nil, fmt.Errorf("unexpected diagnostic report kind: %s", report.Kind)
+	}
+
+	return report.Items, nil
+}
+
+// waitForDiagnostics waits until gopls finished publishing diagnostics for uri after the
+// publish sequence number since: a fresh publish has arrived and no other one followed for
+// diagnosticsSettleTime. Diagnostics published before since are returned if gopls does not
+// republish them within diagnosticsStaleGrace.
+func (c *GoplsClient) waitForDiagnostics(ctx context.Context, uri string, since uint64) ([]protocol.Diagnostic, error) {
+	start := time.Now()
+	deadline := time.NewTimer(diagnosticsTimeout)
+	defer deadline.Stop()
+
+	for {
+		c.diagnosticsMutex.Lock()
+		entry := c.diagnostics[uri]
+		changed := c.diagnosticsChanged
+		c.diagnosticsMutex.Unlock()
+
+		var wait time.Duration
+		switch {
+		case entry != nil && entry.seq > since:
+			wait = diagnosticsSettleTime - time.Since(entry.received)
+		case entry != nil:
+			wait = diagnosticsStaleGrace - time.Since(start)
+		default:
+			wait = diagnosticsTimeout
+		}
+
+		if wait <= 0 {
+			return entry.diagnostics, nil
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-c.transport.Done():
+			return nil, fmt.Errorf("client closed while waiting for diagnostics")
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for diagnostics of %s: %w", uri, ctx.Err())
+		case <-deadline.C:
+			if entry != nil {
+				return entry.diagnostics, nil
+			}
+			return nil, fmt.Errorf("timeout: no diagnostics published for %s after %v seconds", uri, diagnosticsTimeout.Seconds())
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"gemini-tool/protocol"
+)
+
+// maxOpenDocuments bounds the documents kept open in gopls. Opening one more closes the least
+// recently used document, so gopls falls back to the file on disk for it.
+const maxOpenDocuments = 64
+
+// openDocument is a document opened in gopls, with the content gopls currently sees
+type openDocument struct {
+	uri        string
+	languageID string
+	version    int
+	content    string
+	overlay    bool          // content is unsaved source rather than the file on disk
+	element    *list.Element // position in the registry's recently used list
+}
+
+// documentRegistry tracks the documents opened in gopls. Its mutex is held while the
+// notifications of a document are sent, so versions reach gopls in order.
+type documentRegistry struct {
+	mutex     sync.Mutex
+	documents map[string]*openDocument
+	recent    *list.List // URIs, most recently used first
+	limit     int
+}
+
+func newDocumentRegistry(limit int) *documentRegistry {
+	return &documentRegistry{
+		documents: make(map[string]*openDocument),
+		recent:    list.New(),
+		limit:     limit,
+	}
+}
+
+// touch marks a document as the most recently used one
+func (r *documentRegistry) touch(doc *openDocument) {
+	r.recent.MoveToFront(doc.element)
+}
+
+// DidOpen opens a document in gopls with text, or with the file content when text is empty.
+// A document that is already open is not opened twice: when its content differs, the change
+// is sent as a new version, incrementally if gopls supports it. A document holding an overlay
+// keeps it until CloseOverlay. Nothing is sent once ctx is done.
+func (c *GoplsClient) DidOpen(ctx context.Context, uri, languageID, text string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if text == "" {
+		content, err := os.ReadFile(uriToPath(uri))
+		if err != nil {
+			log.Printf("⚠️ Unable to read file content: %v", err)
+		} else {
+			text = string(content)
+		}
+	}
+
+	c.documents.mutex.Lock()
+	defer c.documents.mutex.Unlock()
+
+	_, err := c.setDocument(uri, languageID, text, false)
+	return err
+}
+
+// OpenOverlay replaces the content of a document with unsaved source until CloseOverlay is
+// called; calling it again replaces the overlay. Meanwhile neither DidOpen nor EnsureOpen
+// sync the document with the file on disk.
+func (c *GoplsClient) OpenOverlay(uri, languageID, text string) error {
+	c.documents.mutex.Lock()
+	defer c.documents.mutex.Unlock()
+
+	_, err := c.setDocument(uri, languageID, text, true)
+	return err
+}
+
+// CloseOverlay resets a document opened with OpenOverlay to the file on disk, or closes it
+// when the file does not exist
+func (c *GoplsClient) CloseOverlay(uri string) error {
+	content, readErr := os.ReadFile(uriToPath(uri))
+
+	registry := c.documents
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	doc, ok := registry.documents[uri]
+	if !ok {
+		return nil
+	}
+	doc.overlay = false
+
+	if readErr != nil {
+		return c.closeDocument(uri)
+	}
+	return c.changeDocument(doc, string(content))
+}
+
+// setDocument opens a document, or sends its new content when it is already open. The content
+// of a document holding an overlay is only replaced by another overlay. The caller must hold
+// the registry mutex.
+func (c *GoplsClient) setDocument(uri, languageID, text string, overlay bool) (*openDocument, error) {
+	registry := c.documents
+	if doc, ok := registry.documents[uri]; ok {
+		registry.touch(doc)
+		if doc.overlay && !overlay {
+			log.Printf("🔒 Keeping overlay of %s", uri)
+			return doc, nil
+		}
+		if err := c.changeDocument(doc, text); err != nil {
+			return doc, err
+		}
+		doc.overlay = overlay
+		return doc, nil
+	}
+
+	log.Printf("📝 Opening document: %s", uri)
+
+	for len(registry.documents) >= registry.limit {
+		oldest := registry.recent.Back().Value.(string)
+		if err := c.closeDocument(oldest); err != nil {
+			log.Printf("⚠️ Unable to close least recently used document %s: %v", oldest, err)
+			break
+		}
+	}
+
+	params := protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{
+			URI:        uri,
+			LanguageID: languageID,
+			Version:    1,
+			Text:       text,
+		},
+	}
+
+	if err := c.notify("textDocument/didOpen", params); err != nil {
+		log.Printf("❌ Error opening document: %v", err)
+		return nil, fmt.Errorf("failed to open document: %w", err)
+	}
+
+	doc := &openDocument{
+		uri:        uri,
+		languageID: languageID,
+		version:    1,
+		content:    text,
+		overlay:    overlay,
+	}
+	doc.element = registry.recent.PushFront(uri)
+	registry.documents[uri] = doc
+
+	log.Printf("✓ Document opened successfully: %s (%d open)", uri, len(registry.documents))
+	return doc, nil
+}
+
+// changeDocument sends the new content of an open document. The caller must hold the registry mutex.
+func (c *GoplsClient) changeDocument(doc *openDocument, text string) error {
+	if text == doc.content {
+		return nil
+	}
+
+	change := protocol.TextDocumentContentChangeEvent{Text: text}
+	if c.syncKind() == protocol.SyncIncremental {
+		change = contentChange(doc.content, text)
+	}
+
+	version := doc.version + 1
+	params := protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			URI:     doc.uri,
+			Version: &version,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{change},
+	}
+
+	if err := c.notify("textDocument/didChange", params); err != nil {
+		return fmt.Errorf("failed to update document: %w", err)
+	}
+	doc.version = version
+	doc.content = text
+
+	log.Printf("✓ Document updated: %s (version %d)", doc.uri, version)
+	return nil
+}
+
+// EnsureOpen opens a document from disk. An open document is brought up to date with the
+// file when it changed on disk since, e.g. written by another tool or editor, unless it holds
+// an overlay. Nothing is sent once ctx is done.
+func (c *GoplsClient) EnsureOpen(ctx context.Context, uri string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	content, readErr := os.ReadFile(uriToPath(uri))
+
+	registry := c.documents
+	registry.mutex.Lock()
+	if doc, ok := registry.documents[uri]; ok {
+		defer registry.mutex.Unlock()
+		registry.touch(doc)
+		if doc.overlay || readErr != nil {
+			return nil
+		}
+		return c.changeDocument(doc, string(content))
+	}
+	registry.mutex.Unlock()
+
+	return c.DidOpen(ctx, uri, "go", string(content))
+}
+
+func (c *GoplsClient) IsOpen(uri string) bool {
+	c.documents.mutex.Lock()
+	defer c.documents.mutex.Unlock()
+	_, ok := c.documents.documents[uri]
+	return ok
+}
+
+func (c *GoplsClient) DidClose(uri string) error {
+	c.documents.mutex.Lock()
+	defer c.documents.mutex.Unlock()
+	return c.closeDocument(uri)
+}
+
+// closeDocument closes an open document. The caller must hold the registry mutex.
+func (c *GoplsClient) closeDocument(uri string) error {
+	registry := c.documents
+	doc, ok := registry.documents[uri]
+	if !ok {
+		return nil
+	}
+
+	params := protocol.DidCloseTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+	}
+
+	if err := c.notify("textDocument/didClose", params); err != nil {
+		return err
+	}
+	registry.recent.Remove(doc.element)
+	delete(registry.documents, uri)
+
+	log.Printf("📕 Document closed: %s", uri)
+	return nil
+}
+
+// syncKind returns how gopls wants document changes: textDocumentSync is either the kind
+// itself or options holding it in change. Full content is sent unless gopls asks for increments.
+func (c *GoplsClient) syncKind() protocol.TextDocumentSyncKind {
+	switch option := c.capabilities["textDocumentSync"].(type) {
+	case float64:
+		return protocol.TextDocumentSyncKind(option)
+	case map[string]any:
+		if change, ok := option["change"].(float64); ok {
+			return protocol.TextDocumentSyncKind(change)
+		}
+	}
+	return protocol.SyncFull
+}
+
+// contentChange returns the single range replacement turning before into after: the text
+// between their common prefix and common suffix, cut on rune and line ending boundaries
+func contentChange(before, after string) protocol.TextDocumentContentChangeEvent {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	for prefix > 0 && !(runeStartAt(before, prefix) && runeStartAt(after, prefix)) {
+		prefix--
+	}
+
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && !(runeStartAt(before, len(before)-suffix) && runeStartAt(after, len(after)-suffix)) {
+		suffix--
+	}
+
+	content := []byte(before)
+	return protocol.TextDocumentContentChangeEvent{
+		Range: &protocol.Range{
+			Start: offsetToPosition(content, prefix),
+			End:   offsetToPosition(content, len(before)-suffix),
+		},
+		Text: after[prefix : len(after)-suffix],
+	}
+}
+
+// runeStartAt reports whether offset is a rune boundary of s that does not split a "\r\n"
+func runeStartAt(s string, offset int) bool {
+	if offset >= len(s) {
+		return true
+	}
+	if offset > 0 && s[offset-1] == '\r' && s[offset] == '\n' {
+		return false
+	}
+	return utf8.RuneStart(s[offset])
+}
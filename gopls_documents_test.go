@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gemini-tool/protocol"
+
+	"go.uber.org/zap"
+)
+
+func TestContentChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		before    string
+		after     string
+		wantStart protocol.Position
+		wantEnd   protocol.Position
+		wantText  string
+	}{
+		{
+			name:      "replace a word",
+			before:    "func old() {}\n",
+			after:     "func new() {}\n",
+			wantStart: protocol.Position{Line: 0, Character: 5},
+			wantEnd:   protocol.Position{Line: 0, Character: 8},
+			wantText:  "new",
+		},
+		{
+			name:      "insert a line",
+			before:    "a\nc\n",
+			after:     "a\nb\nc\n",
+			wantStart: protocol.Position{Line: 1, Character: 0},
+			wantEnd:   protocol.Position{Line: 1, Character: 0},
+			wantText:  "b\n",
+		},
+		{
+			name:      "delete everything",
+			before:    "package main\n",
+			after:     "",
+			wantStart: protocol.Position{Line: 0, Character: 0},
+			wantEnd:   protocol.Position{Line: 1, Character: 0},
+			wantText:  "",
+		},
+		{
+			name:      "fill an empty document",
+			before:    "",
+			after:     "package main\n",
+			wantStart: protocol.Position{Line: 0, Character: 0},
+			wantEnd:   protocol.Position{Line: 0, Character: 0},
+			wantText:  "package main\n",
+		},
+		{
+			name:      "identical",
+			before:    "same\n",
+			after:     "same\n",
+			wantStart: protocol.Position{Line: 1, Character: 0},
+			wantEnd:   protocol.Position{Line: 1, Character: 0},
+			wantText:  "",
+		},
+		{
+			name:      "runes sharing leading bytes are not split",
+			before:    "s := \"é\"\n", // é is C3 A9
+			after:     "s := \"ã\"\n", // ã is C3 A3
+			wantStart: protocol.Position{Line: 0, Character: 6},
+			wantEnd:   protocol.Position{Line: 0, Character: 7},
+			wantText:  "ã",
+		},
+		{
+			name:      "positions count UTF-16 units",
+			before:    "😀 a\n",
+			after:     "😀 b\n",
+			wantStart: protocol.Position{Line: 0, Character: 3},
+			wantEnd:   protocol.Position{Line: 0, Character: 4},
+			wantText:  "b",
+		},
+		{
+			name:      "CRLF line endings are not split",
+			before:    "a\r\nb\r\n",
+			after:     "a\nb\r\n",
+			wantStart: protocol.Position{Line: 0, Character: 1},
+			wantEnd:   protocol.Position{Line: 1, Character: 0},
+			wantText:  "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := contentChange(tt.before, tt.after)
+			if change.Range == nil || change.Range.Start != tt.wantStart || change.Range.End != tt.wantEnd || change.Text != tt.wantText {
+				t.Errorf("contentChange() = %+v %q, want %+v-%+v %q", change.Range, change.Text, tt.wantStart, tt.wantEnd, tt.wantText)
+			}
+
+			applied, err := applyTextEdits([]byte(tt.before), []protocol.TextEdit{{Range: *change.Range, NewText: change.Text}})
+			if err != nil || string(applied) != tt.after {
+				t.Errorf("applying the change gives %q, %v, want %q", applied, err, tt.after)
+			}
+		})
+	}
+}
+
+// newTestGoplsClient returns an initialized client talking to a fake gopls server
+func newTestGoplsClient(t *testing.T) (*GoplsClient, *protocol.Transport) {
+	t.Helper()
+
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+	t.Cleanup(func() {
+		clientWriter.Close()
+		serverWriter.Close()
+	})
+
+	server := protocol.NewTransport(serverReader, serverWriter)
+	server.Start()
+
+	client := newGoplsClient(t.TempDir(), protocol.NewTransport(clientReader, clientWriter), GoplsConfig{})
+	client.initialized.Store(true)
+	return client, server
+}
+
+// recordNotifications collects the notifications with the given methods the fake gopls receives
+func recordNotifications(server *protocol.Transport, methods ...string) <-chan *protocol.JSONRPCMessage {
+	notifications := make(chan *protocol.JSONRPCMessage, 16)
+	for _, method := range methods {
+		server.Subscribe(method, func(msg *protocol.JSONRPCMessage) {
+			notifications <- msg
+		})
+	}
+	return notifications
+}
+
+// receiveNotification waits for the next notification received by the fake gopls
+func receiveNotification(t *testing.T, notifications <-chan *protocol.JSONRPCMessage) *protocol.JSONRPCMessage {
+	t.Helper()
+
+	select {
+	case msg := <-notifications:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a notification")
+		return nil
+	}
+}
+
+func TestEnsureOpenSyncsDiskChanges(t *testing.T) {
+	client, server := newTestGoplsClient(t)
+	notifications := recordNotifications(server,
+		"textDocument/didOpen", "textDocument/didChange", "textDocument/didClose")
+
+	path := filepath.Join(t.TempDir(), "main.go")
+	uri := pathToURI(path)
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type sent struct {
+		method string
+		text   string
+	}
+	var want []sent
+
+	write("package a\n")
+	if err := client.EnsureOpen(context.Background(), uri); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, sent{"textDocument/didOpen", "package a\n"})
+
+	// Unchanged on disk: nothing is sent
+	if err := client.EnsureOpen(context.Background(), uri); err != nil {
+		t.Fatal(err)
+	}
+
+	write("package b\n")
+	if err := client.EnsureOpen(context.Background(), uri); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, sent{"textDocument/didChange", "package b\n"})
+
+	// An overlay is not replaced by the file on disk until it is closed
+	if err := client.OpenOverlay(uri, "go", "package overlay\n"); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, sent{"textDocument/didChange", "package overlay\n"})
+
+	write("package c\n")
+	if err := client.EnsureOpen(context.Background(), uri); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseOverlay(uri); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, sent{"textDocument/didChange", "package c\n"})
+
+	if err := client.DidClose(uri); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, sent{method: "textDocument/didClose"})
+
+	for i, expected := range want {
+		msg := receiveNotification(t, notifications)
+
+		var params struct {
+			TextDocument   protocol.TextDocumentItem                 `json:"textDocument"`
+			ContentChanges []protocol.TextDocumentContentChangeEvent `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			t.Fatal(err)
+		}
+		text := params.TextDocument.Text
+		if len(params.ContentChanges) > 0 {
+			text = params.ContentChanges[0].Text
+		}
+
+		if msg.Method != expected.method || text != expected.text {
+			t.Errorf("notification %d = %s %q, want %s %q", i, msg.Method, text, expected.method, expected.text)
+		}
+	}
+}
+
+func TestOverlaySurvivesConcurrentOpen(t *testing.T) {
+	client, server := newTestGoplsClient(t)
+	notifications := recordNotifications(server, "textDocument/didOpen", "textDocument/didChange")
+
+	path := filepath.Join(t.TempDir(), "main.go")
+	writeTestFile(t, path, "package disk\n")
+
+	gt := NewGoplsTool(zap.NewNop())
+	err := gt.withOverlay(client, path, "package overlay\n", func(uri string) error {
+		// Tools working on the file content open it meanwhile
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if err := client.DidOpen(context.Background(), uri, "go", ""); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if err := gt.initializeWorkspace(context.Background(), client, path); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		client.documents.mutex.Lock()
+		defer client.documents.mutex.Unlock()
+		if doc := client.documents.documents[uri]; doc == nil || doc.content != "package overlay\n" || !doc.overlay {
+			t.Errorf("document = %+v during the overlay, want the overlay content", doc)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gopls only sees the overlay, then the file once the overlay is closed
+	want := []string{"package overlay\n", "package disk\n"}
+	for i, expected := range want {
+		msg := receiveNotification(t, notifications)
+
+		var params struct {
+			TextDocument   protocol.TextDocumentItem                 `json:"textDocument"`
+			ContentChanges []protocol.TextDocumentContentChangeEvent `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			t.Fatal(err)
+		}
+		text := params.TextDocument.Text
+		if len(params.ContentChanges) > 0 {
+			text = params.ContentChanges[0].Text
+		}
+		if text != expected {
+			t.Errorf("notification %d = %s %q, want %q", i, msg.Method, text, expected)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"gemini-tool/protocol"
+)
+
+// handleApplyEdit answers workspace/applyEdit requests, which gopls sends while executing a
+// command. The edit goes to the sink of the running ExecuteCommand call.
+func (c *GoplsClient) handleApplyEdit(msg *protocol.JSONRPCMessage) (any, error) {
+	var params protocol.ApplyWorkspaceEditParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, fmt.Errorf("invalid applyEdit params: %w", err)
+	}
+
+	c.editSinkMutex.Lock()
+	sink := c.editSink
+	c.editSinkMutex.Unlock()
+
+	if sink == nil {
+		log.Printf("⚠️ Rejecting workspace edit %q: no command is running", params.Label)
+		return protocol.ApplyWorkspaceEditResult{Applied: false, FailureReason: "no command is waiting for edits"}, nil
+	}
+
+	if err := sink(params.Edit); err != nil {
+		log.Printf("❌ Failed to apply workspace edit %q: %v", params.Label, err)
+		return protocol.ApplyWorkspaceEditResult{Applied: false, FailureReason: err.Error()}, nil
+	}
+
+	log.Printf("✏️ Workspace edit applied: %s", params.Label)
+	return protocol.ApplyWorkspaceEditResult{Applied: true}, nil
+}
+
+func (c *GoplsClient) Rename(ctx context.Context, uri string, line, character int, newName string) (*protocol.WorkspaceEdit, error) {
+	if err := c.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
+	}
+
+	params := protocol.RenameParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{
+				URI: uri,
+			},
+			Position: protocol.Position{
+				Line:      line,
+				Character: character,
+			},
+		},
+		NewName: newName,
+	}
+
+	resp, err := c.call(ctx, "textDocument/rename", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return nil, fmt.Errorf("nothing to rename at this position")
+	}
+
+	var edit protocol.WorkspaceEdit
+	if err := resp.ParseResult(&edit); err != nil {
+		return nil, fmt.Errorf("failed to decode rename edits: %w", err)
+	}
+
+	return &edit, nil
+}
+
+func (c *GoplsClient) CodeActions(ctx context.Context, uri string, rng protocol.Range, diagnostics []protocol.Diagnostic, only []string) ([]protocol.CodeAction, error) {
+	if err := c.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
+	}
+
+	if diagnostics == nil {
+		diagnostics = []protocol.Diagnostic{}
+	}
+
+	params := protocol.CodeActionParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		Range: rng,
+		Context: protocol.CodeActionContext{
+			Diagnostics: diagnostics,
+			Only:        only,
+		},
+	}
+
+	resp, err := c.call(ctx, "textDocument/codeAction", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []protocol.CodeAction
+	if err := resp.ParseResult(&actions); err != nil {
+		return nil, fmt.Errorf("failed to decode code actions: %w", err)
+	}
+
+	return actions, nil
+}
+
+// ExecuteCommand runs a server command. Workspace edits the server asks the client to apply
+// while the command runs are passed to onEdit; without onEdit they are rejected. Commands
+// run one at a time so their edits cannot be mixed up.
+func (c *GoplsClient) ExecuteCommand(ctx context.Context, command string, arguments []any, onEdit func(protocol.WorkspaceEdit) error) (json.RawMessage, error) {
+	c.commandMutex.Lock()
+	defer c.commandMutex.Unlock()
+
+	c.editSinkMutex.Lock()
+	c.editSink = onEdit
+	c.editSinkMutex.Unlock()
+
+	defer func() {
+		c.editSinkMutex.Lock()
+		c.editSink = nil
+		c.editSinkMutex.Unlock()
+	}()
+
+	params := protocol.ExecuteCommandParams{
+		Command:   command,
+		Arguments: arguments,
+	}
+
+	resp, err := c.call(ctx, "workspace/executeCommand", params)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Result, nil
+}
+
+func (c *GoplsClient) Formatting(ctx context.Context, uri string) ([]protocol.TextEdit, error) {
+	if err := c.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
+	}
+
+	params := protocol.DocumentFormattingParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		Options: protocol.FormattingOptions{
+			TabSize:      4,
+			InsertSpaces: false,
+		},
+	}
+
+	resp, err := c.call(ctx, "textDocument/formatting", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []protocol.TextEdit
+	if err := resp.ParseResult(&edits); err != nil {
+		return nil, fmt.Errorf("failed to decode formatting edits: %w", err)
+	}
+
+	return edits, nil
+}
+
+func (c *GoplsClient) DidChangeWatchedFiles(changes []protocol.FileEvent) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return c.notify("workspace/didChangeWatchedFiles", protocol.DidChangeWatchedFilesParams{Changes: changes})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gemini-tool/protocol"
+)
+
+const (
+	// idleSettleTime is how long gopls must report no work before it is considered idle,
+	// so that a task starting right after another one ends is not missed
+	idleSettleTime = 200 * time.Millisecond
+	// initialLoadGrace is how long to wait for gopls to report its initial workspace load;
+	// past it, a session that reported no progress at all is considered loaded
+	initialLoadGrace = 2 * time.Second
+	// idleTimeout bounds how long queries wait for gopls to become idle
+	idleTimeout = 60 * time.Second
+	// progressStaleTime is how long a task may go without any report before it is considered
+	// lost, e.g. gopls never sent its end, so that it does not keep every query waiting
+	progressStaleTime = 30 * time.Second
+)
+
+// progressTask is a work done progress gopls created or began
+type progressTask struct {
+	title   string
+	message string
+	begun   time.Time
+	updated time.Time // last begin or report
+}
+
+func (c *GoplsClient) handleWorkDoneProgressCreate(msg *protocol.JSONRPCMessage) (any, error) {
+	var params protocol.WorkDoneProgressCreateParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, fmt.Errorf("invalid workDoneProgress/create params: %w", err)
+	}
+
+	c.progressMutex.Lock()
+	defer c.progressMutex.Unlock()
+
+	// Created tokens count as work right away: the begin notification follows shortly
+	now := time.Now()
+	c.progress[string(params.Token)] = &progressTask{begun: now, updated: now}
+	c.signalProgress()
+	return nil, nil
+}
+
+func (c *GoplsClient) handleProgress(msg *protocol.JSONRPCMessage) {
+	var params protocol.ProgressParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		log.Printf("⚠️ Invalid $/progress params: %v", err)
+		return
+	}
+
+	var value protocol.WorkDoneProgressValue
+	if err := json.Unmarshal(params.Value, &value); err != nil || value.Kind == "" {
+		return // not a work done progress
+	}
+
+	token := string(params.Token)
+
+	c.progressMutex.Lock()
+	defer c.progressMutex.Unlock()
+
+	switch value.Kind {
+	case "begin":
+		now := time.Now()
+		c.progress[token] = &progressTask{title: value.Title, message: value.Message, begun: now, updated: now}
+		c.progressSeen = true
+		log.Printf("⏳ gopls started: %s %s", value.Title, value.Message)
+	case "report":
+		if task := c.progress[token]; task != nil {
+			task.updated = time.Now()
+			if value.Message != "" {
+				task.message = value.Message
+			}
+		}
+	case "end":
+		if task := c.progress[token]; task != nil {
+			log.Printf("✓ gopls finished: %s in %v", task.title, time.Since(task.begun).Round(time.Millisecond))
+		}
+		delete(c.progress, token)
+	}
+	c.signalProgress()
+}
+
+// signalProgress records a progress event and wakes up every waiter. The caller must hold c.progressMutex.
+func (c *GoplsClient) signalProgress() {
+	c.lastProgress = time.Now()
+	close(c.progressChanged)
+	c.progressChanged = make(chan struct{})
+}
+
+// expireStaleProgress forgets the tasks that had no report for progressStaleTime. It returns
+// the number of tasks still in progress and how long until the next one would expire. The
+// caller must hold c.progressMutex.
+func (c *GoplsClient) expireStaleProgress() (int, time.Duration) {
+	next := progressStaleTime
+	for token, task := range c.progress {
+		remaining := progressStaleTime - time.Since(task.updated)
+		if remaining <= 0 {
+			log.Printf("⚠️ gopls task %s %q reported nothing for %v, no longer waiting for it", token, task.title, progressStaleTime)
+			delete(c.progress, token)
+			continue
+		}
+		next = min(next, remaining)
+	}
+	return len(c.progress), next
+}
+
+// resetProgress forgets every task in progress and wakes up the waiters
+func (c *GoplsClient) resetProgress() {
+	c.progressMutex.Lock()
+	defer c.progressMutex.Unlock()
+
+	clear(c.progress)
+	c.signalProgress()
+}
+
+// WaitForIdle blocks until gopls has finished its initial workspace load and reports no work
+// in progress, such as package loading or diagnostics, or until ctx is done. Tasks that
+// stopped reporting for progressStaleTime are not waited for.
+func (c *GoplsClient) WaitForIdle(ctx context.Context) error {
+	for {
+		c.progressMutex.Lock()
+		active, expiry := c.expireStaleProgress()
+		wait := idleSettleTime - time.Since(c.lastProgress)
+		if !c.progressSeen {
+			wait = max(wait, initialLoadGrace-time.Since(c.initializedAt))
+		}
+		changed := c.progressChanged
+		c.progressMutex.Unlock()
+
+		if active == 0 && wait <= 0 {
+			return nil
+		}
+
+		// With work in progress, only a progress event or a task expiring can make gopls idle
+		if active > 0 {
+			wait = expiry
+		}
+		timer := time.NewTimer(wait)
+
+		var err error
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-c.transport.Done():
+			err = fmt.Errorf("gopls connection closed while waiting for it to become idle")
+		case <-ctx.Done():
+			err = fmt.Errorf("gopls still busy with %d tasks: %w", active, ctx.Err())
+		}
+
+		timer.Stop()
+		if err != nil {
+			return err
+		}
+	}
+}
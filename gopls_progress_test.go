@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitForIdleExpiresStaleTasks(t *testing.T) {
+	tests := []struct {
+		name     string
+		updated  time.Duration // age of the last report of the task in progress
+		reset    bool
+		wantIdle bool
+	}{
+		{name: "task reporting recently is waited for", updated: 0, wantIdle: false},
+		{name: "task silent for too long is expired", updated: progressStaleTime - 50*time.Millisecond, wantIdle: true},
+		{name: "reset forgets every task", updated: 0, reset: true, wantIdle: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newTestGoplsClient(t)
+
+			client.progressMutex.Lock()
+			client.progressSeen = true
+			client.lastProgress = time.Now().Add(-time.Minute)
+			client.progress[`"load"`] = &progressTask{
+				title:   "Loading packages",
+				begun:   time.Now().Add(-tt.updated),
+				updated: time.Now().Add(-tt.updated),
+			}
+			client.progressMutex.Unlock()
+
+			if tt.reset {
+				client.resetProgress()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err := client.WaitForIdle(ctx)
+			if idle := err == nil; idle != tt.wantIdle {
+				t.Errorf("WaitForIdle() = %v, want idle %v", err, tt.wantIdle)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gemini-tool/protocol"
+)
+
+// dialTimeout bounds a single connection attempt to a gopls daemon
+const dialTimeout = 5 * time.Second
+
+// ReconnectPolicy controls how connections to a gopls daemon are retried: each attempt
+// waits twice as long as the previous one, up to MaxBackoff
+type ReconnectPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultReconnectPolicy is used when GoplsConfig.Reconnect is left empty
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// NewRemoteGoplsClient connects to the gopls daemon at config.Remote, e.g. one started with
+// "gopls serve -listen=unix;/tmp/gopls.sock", so that several processes share its warm cache.
+// The daemon must see the workspace at the same paths as this process. Failed connections
+// are retried following config.Reconnect until ctx is done; ctx does not bound the session.
+func NewRemoteGoplsClient(ctx context.Context, rootDir string, config GoplsConfig) (*GoplsClient, error) {
+	rootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace root %q: %w", rootDir, err)
+	}
+
+	network, address, err := parseRemote(config.Remote)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialGopls(ctx, network, address, config.reconnectPolicy())
+	if err != nil {
+		return nil, err
+	}
+
+	client := newGoplsClient(rootDir, protocol.NewConnTransport(conn), config)
+	client.remote = true
+
+	// There is no process to wait for: the session ends with the connection
+	go func() {
+		<-client.transport.Done()
+		log.Printf("⚠️ gopls daemon connection closed: %v", client.transport.Err())
+		close(client.exited)
+	}()
+
+	log.Printf("✅ Gopls client connected to %s %s (workspace root: %s)", network, address, rootDir)
+	return client, nil
+}
+
+// parseRemote parses a gopls -remote address: "unix;/path/to/socket", "tcp;host:port",
+// "tcp:host:port" or "host:port". A leading "-remote=" is accepted too.
+func parseRemote(remote string) (network, address string, err error) {
+	remote = strings.TrimPrefix(strings.TrimSpace(remote), "-remote=")
+
+	if network, address, ok := strings.Cut(remote, ";"); ok {
+		if network != "unix" && network != "tcp" {
+			return "", "", fmt.Errorf("unsupported gopls remote network %q, expected unix or tcp", network)
+		}
+		if address == "" {
+			return "", "", fmt.Errorf("missing address in gopls remote %q", remote)
+		}
+		return network, address, nil
+	}
+
+	if remote == "auto" || strings.HasPrefix(remote, "auto;") {
+		return "", "", fmt.Errorf("automatic gopls daemons are not supported, give the daemon address instead")
+	}
+
+	address = strings.TrimPrefix(remote, "tcp:")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", "", fmt.Errorf("invalid gopls remote %q, expected unix;/path or tcp:host:port: %w", remote, err)
+	}
+	return "tcp", address, nil
+}
+
+// dialGopls connects to a gopls daemon, retrying with exponential backoff until ctx is done
+func dialGopls(ctx context.Context, network, address string, policy ReconnectPolicy) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	backoff := policy.InitialBackoff
+
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("connection to gopls at %s %s cancelled: %w", network, address, ctx.Err())
+		}
+
+		log.Printf("⚠️ Connection to gopls at %s %s failed (attempt %d/%d): %v",
+			network, address, attempt, policy.MaxAttempts, err)
+		if attempt < policy.MaxAttempts {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("connection to gopls at %s %s cancelled: %w", network, address, ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, policy.MaxBackoff)
+		}
+	}
+
+	return nil, fmt.Errorf("failed to connect to gopls at %s %s after %d attempts: %w",
+		network, address, policy.MaxAttempts, err)
+}
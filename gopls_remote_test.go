@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gemini-tool/protocol"
+)
+
+func TestParseRemote(t *testing.T) {
+	tests := []struct {
+		remote      string
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{remote: "unix;/tmp/gopls.sock", wantNetwork: "unix", wantAddress: "/tmp/gopls.sock"},
+		{remote: "-remote=unix;/tmp/gopls.sock", wantNetwork: "unix", wantAddress: "/tmp/gopls.sock"},
+		{remote: " tcp;localhost:37374 ", wantNetwork: "tcp", wantAddress: "localhost:37374"},
+		{remote: "tcp:localhost:37374", wantNetwork: "tcp", wantAddress: "localhost:37374"},
+		{remote: "localhost:37374", wantNetwork: "tcp", wantAddress: "localhost:37374"},
+		{remote: "[::1]:37374", wantNetwork: "tcp", wantAddress: "[::1]:37374"},
+		{remote: "auto", wantErr: true},
+		{remote: "auto;/tmp/gopls-daemon", wantErr: true},
+		{remote: "udp;localhost:37374", wantErr: true},
+		{remote: "unix;", wantErr: true},
+		{remote: "/tmp/gopls.sock", wantErr: true},
+		{remote: "localhost", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			network, address, err := parseRemote(tt.remote)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseRemote(%q) = %s %s, want an error", tt.remote, network, address)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRemote(%q) failed: %v", tt.remote, err)
+			}
+			if network != tt.wantNetwork || address != tt.wantAddress {
+				t.Errorf("parseRemote(%q) = %s %s, want %s %s", tt.remote, network, address, tt.wantNetwork, tt.wantAddress)
+			}
+		})
+	}
+}
+
+func TestCloseShutdownSequence(t *testing.T) {
+	tests := []struct {
+		name         string
+		remote       bool
+		shutdownErr  error
+		wantShutdown bool
+		wantExit     bool
+	}{
+		{name: "local gopls is shut down then exits", wantShutdown: true, wantExit: true},
+		{name: "no exit when shutdown fails", shutdownErr: errors.New("busy"), wantShutdown: true},
+		{name: "shared daemon is left running", remote: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newTestGoplsClient(t)
+			client.remote = tt.remote
+
+			shutdowns := make(chan struct{}, 1)
+			server.HandleRequest("shutdown", func(msg *protocol.JSONRPCMessage) (any, error) {
+				shutdowns <- struct{}{}
+				return nil, tt.shutdownErr
+			})
+			exits := recordNotifications(server, "exit")
+
+			client.Close()
+
+			select {
+			case <-shutdowns:
+				if !tt.wantShutdown {
+					t.Error("shutdown was sent")
+				}
+			default:
+				if tt.wantShutdown {
+					t.Error("shutdown was not sent")
+				}
+			}
+
+			// Close returns once exit is written, but the fake may still be reading it
+			select {
+			case <-exits:
+				if !tt.wantExit {
+					t.Error("exit was sent")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantExit {
+					t.Error("exit was not sent")
+				}
+			}
+		})
+	}
+}
+
+func TestDialGopls(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen on a local port:", err)
+	}
+	defer listener.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen on a local port:", err)
+	}
+	refused := closed.Addr().String()
+	closed.Close()
+
+	// Backoffs far longer than the test: only cancellation ends the retries
+	policy := ReconnectPolicy{MaxAttempts: 10, InitialBackoff: time.Minute, MaxBackoff: time.Minute}
+
+	t.Run("daemon listening", func(t *testing.T) {
+		conn, err := dialGopls(context.Background(), "tcp", listener.Addr().String(), policy)
+		if err != nil {
+			t.Fatalf("dialGopls() failed: %v", err)
+		}
+		conn.Close()
+	})
+
+	t.Run("cancelled while waiting to retry", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := dialGopls(ctx, "tcp", refused, policy)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("dialGopls() = %v, want the context error", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("dialGopls() returned after %v, want it to stop with the context", elapsed)
+		}
+	})
+
+	t.Run("cancelled before dialing", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := dialGopls(ctx, "tcp", listener.Addr().String(), policy); !errors.Is(err, context.Canceled) {
+			t.Errorf("dialGopls() = %v, want context.Canceled", err)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gemini-tool/protocol"
+)
+
+func TestImportPath(t *testing.T) {
+	module := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(module, "internal", "store"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(module, "go.mod"), "module example.com/app\n\ngo 1.24\n")
+
+	unnamed := t.TempDir()
+	writeTestFile(t, filepath.Join(unnamed, "go.mod"), "go 1.24\n")
+
+	tests := []struct {
+		name string
+		dir  string
+		want string
+	}{
+		{name: "module root", dir: module, want: "example.com/app"},
+		{name: "nested package", dir: filepath.Join(module, "internal", "store"), want: "example.com/app/internal/store"},
+		{name: "standard library", dir: filepath.Join(build.Default.GOROOT, "src", "net", "http"), want: "net/http"},
+		{name: "go.mod without module path", dir: unnamed, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := importPath(tt.dir); got != tt.want {
+				t.Errorf("importPath(%q) = %q, want %q", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+// syncBuffer collects log output written from several goroutines
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestCallCancelled(t *testing.T) {
+	client, server := newTestGoplsClient(t)
+	cancels := recordNotifications(server, "$/cancelRequest")
+
+	// The fake gopls only answers once the test releases it
+	received := make(chan *protocol.JSONRPCMessage, 1)
+	release := make(chan struct{})
+	server.HandleRequest("textDocument/hover", func(msg *protocol.JSONRPCMessage) (any, error) {
+		received <- msg
+		<-release
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.call(ctx, "textDocument/hover", nil)
+		errs <- err
+	}()
+
+	var request *protocol.JSONRPCMessage
+	select {
+	case request = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("gopls did not receive the request")
+	}
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("call() = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("call() did not return once cancelled")
+	}
+
+	var params protocol.CancelParams
+	if err := json.Unmarshal(receiveNotification(t, cancels).Params, &params); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(params.ID) != fmt.Sprint(request.ID) {
+		t.Errorf("$/cancelRequest for id %v, want %v", params.ID, request.ID)
+	}
+
+	// The late response finds no waiter, since the client forgot the request
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	close(release)
+
+	want := fmt.Sprintf("No waiter for response ID %v", request.ID)
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(logs.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("log = %q, want a line containing %q", logs.String(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewGoplsClientCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client, err := NewGoplsClient(ctx, t.TempDir(), GoplsConfig{})
+	if !errors.Is(err, context.Canceled) {
+		if client != nil {
+			client.Close()
+		}
+		t.Errorf("NewGoplsClient() = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,1667 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gemini-tool/protocol"
+
+	"go.uber.org/zap"
+)
+
+// GoplsTool represents the gopls integration tool. It owns a single long-lived gopls
+// session that is started on first use, shared by all function calls and restarted
+// automatically if the gopls process dies.
+type GoplsTool struct {
+	logger        *zap.Logger
+	mutex         sync.Mutex
+	goplsClient   *GoplsClient
+	restarts      int
+	workspaceRoot string
+	config        GoplsConfig
+
+	overlayMutex sync.Mutex
+	overlays     map[string]*sync.Mutex // per document URI, held while unsaved source replaces it
+}
+
+// NewGoplsTool creates a new gopls tool instance. gopls itself is started lazily.
+func NewGoplsTool(logger *zap.Logger) *GoplsTool {
+	return &GoplsTool{
+		logger:   logger,
+		overlays: make(map[string]*sync.Mutex),
+	}
+}
+
+// acquire returns the gopls client to use for an operation on filePath, once gopls is done
+// loading the workspace. The session lock is only held while the session is looked up or
+// (re)started; the client itself supports concurrent requests.
+func (gt *GoplsTool) acquire(ctx context.Context, filePath string) (*GoplsClient, error) {
+	gt.mutex.Lock()
+	client, err := gt.session(ctx, filePath)
+	gt.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	idleCtx, cancel := context.WithTimeout(ctx, idleTimeout)
+	defer cancel()
+	if err := client.WaitForIdle(idleCtx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		gt.logger.Warn("gopls is still busy, querying anyway", zap.Error(err))
+	}
+
+	return client, nil
+}
+
+// SetWorkspaceRoot sets an explicit workspace root for the next gopls session. When unset,
+// the root is detected from the first analyzed file by walking up to go.work or go.mod.
+func (gt *GoplsTool) SetWorkspaceRoot(root string) {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	gt.workspaceRoot = root
+}
+
+// SetConfig sets how gopls is launched and the settings it runs with. It applies to the next
+// gopls session; a running session keeps its configuration.
+func (gt *GoplsTool) SetConfig(config GoplsConfig) {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	gt.config = config
+}
+
+// session returns a healthy, initialized gopls client for filePath, starting or restarting
+// gopls when needed and adding the file's module as a workspace folder if it is not covered yet.
+// The caller must hold gt.mutex.
+func (gt *GoplsTool) session(ctx context.Context, filePath string) (*GoplsClient, error) {
+	client, err := gt.liveClient(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.AddWorkspaceFolder(FindWorkspaceRoot(filePath)); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// liveClient returns the running gopls client, starting a new one rooted at the configured
+// workspace root (or the workspace of filePath) when there is none or it died.
+// The caller must hold gt.mutex.
+func (gt *GoplsTool) liveClient(ctx context.Context, filePath string) (*GoplsClient, error) {
+	if gt.goplsClient != nil {
+		if gt.goplsClient.IsAlive() {
+			return gt.goplsClient, nil
+		}
+
+		gt.restarts++
+		gt.logger.Warn("gopls session is no longer alive, restarting",
+			zap.Int("restarts", gt.restarts),
+			zap.String("remote", gt.config.Remote))
+		if err := gt.goplsClient.Close(); err != nil {
+			gt.logger.Debug("Error closing dead gopls session", zap.Error(err))
+		}
+		gt.goplsClient = nil
+	}
+
+	root := gt.workspaceRoot
+	if root == "" {
+		root = FindWorkspaceRoot(filePath)
+	}
+
+	client, err := NewGoplsClient(ctx, root, gt.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gopls client: %w", err)
+	}
+
+	if err := client.Initialize(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to initialize gopls: %w", err)
+	}
+
+	gt.logger.Info("Started gopls session",
+		zap.String("workspaceRoot", client.RootDir()),
+		zap.String("goplsPath", gt.config.Path),
+		zap.String("goplsRemote", gt.config.Remote),
+		zap.Strings("goplsArgs", gt.config.Args),
+		zap.Any("goplsSettings", gt.config.Settings))
+	gt.goplsClient = client
+	return client, nil
+}
+
+// SymbolDefinition is the definition of a requested symbol. Range is the 0-based LSP range
+// of the whole declaration. Error is set instead of the other details when the symbol could
+// not be resolved.
+type SymbolDefinition struct {
+	Name      string          `json:"name"`
+	Kind      string          `json:"kind,omitempty"`
+	URI       string          `json:"uri,omitempty"`
+	Range     *protocol.Range `json:"range,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+	Source    string          `json:"source,omitempty"`
+	Doc       string          `json:"doc,omitempty"`
+	Methods   []string        `json:"methods,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// GetCodeDefinitions retrieves definitions for the requested symbols from gopls. Each definition
+// is expanded to its whole declaration; with signatureOnly, the full source is left out.
+func (gt *GoplsTool) GetCodeDefinitions(ctx context.Context, filePath string, symbols []string, signatureOnly bool) ([]SymbolDefinition, error) {
+	gt.logger.Debug("Getting code definitions from gopls",
+		zap.String("filePath", filePath),
+		zap.Strings("symbols", symbols))
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the file in the workspace
+	err = gt.initializeWorkspace(ctx, client, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize workspace: %w", err)
+	}
+
+	// Read the file content to find symbol positions
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	definitions := make([]SymbolDefinition, 0, len(symbols))
+	for _, symbol := range symbols {
+		gt.logger.Debug("Looking up symbol", zap.String("symbol", symbol))
+		definitions = append(definitions, gt.lookupDefinition(ctx, client, filePath, content, symbol, signatureOnly))
+	}
+
+	gt.logger.Info("Successfully retrieved code definitions",
+		zap.Int("symbolCount", len(symbols)))
+
+	return definitions, nil
+}
+
+// lookupDefinition resolves a single symbol and describes its definition
+func (gt *GoplsTool) lookupDefinition(ctx context.Context, client *GoplsClient, filePath string, content []byte, symbol string, signatureOnly bool) SymbolDefinition {
+	definition := SymbolDefinition{Name: symbol}
+
+	// Resolve the symbol to an identifier in the file or its package
+	match, err := resolveSymbol(filePath, content, symbol)
+	if err != nil {
+		var notFound *SymbolNotFoundError
+		if errors.As(err, &notFound) {
+			definition.Error = "not found in file"
+		} else {
+			definition.Error = err.Error()
+		}
+		return definition
+	}
+	definition.Kind = match.Kind
+
+	// Get definition from gopls
+	location, err := gt.getDefinitionAtPosition(ctx, client, match.File, match.Position)
+	if err != nil {
+		gt.logger.Warn("Failed to get definition for symbol",
+			zap.String("symbol", symbol),
+			zap.Error(err))
+		definition.Error = fmt.Sprintf("error getting definition: %v", err)
+		return definition
+	}
+	definition.URI = location.URI
+	definition.Range = &location.Range
+
+	// Expand the location to the enclosing declaration
+	declaration, err := enclosingDeclaration(uriToPath(location.URI), location.Range.Start)
+	if err != nil {
+		gt.logger.Debug("No enclosing declaration, returning location range",
+			zap.String("uri", location.URI),
+			zap.Error(err))
+
+		source, err := gt.getCodeAtLocation(location)
+		if err == nil {
+			definition.Source = source
+		}
+		return definition
+	}
+
+	definition.Kind = declaration.Kind
+	definition.Range = &declaration.Range
+	definition.Signature = declaration.Signature
+	definition.Doc = declaration.Doc
+	definition.Methods = declaration.Methods
+	if !signatureOnly && declaration.Source != declaration.Signature {
+		definition.Source = declaration.Source
+	}
+
+	return definition
+}
+
+// DiagnosticResult is a diagnostic reported by gopls for a file, with 1-based positions
+type DiagnosticResult struct {
+	Severity  string `json:"severity"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	Code      any    `json:"code,omitempty"`
+	Source    string `json:"source,omitempty"`
+	Message   string `json:"message"`
+}
+
+// GetDiagnostics returns the compile errors and analyzer findings gopls reports for a file.
+// source, when set, is checked instead of the file content, as for Completion.
+func (gt *GoplsTool) GetDiagnostics(ctx context.Context, filePath, source string) ([]DiagnosticResult, error) {
+	gt.logger.Debug("Getting diagnostics from gopls",
+		zap.String("filePath", filePath),
+		zap.Int("sourceLength", len(source)))
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var diagnostics []protocol.Diagnostic
+	err = gt.withOverlay(client, filePath, source, func(uri string) error {
+		var err error
+		diagnostics, err = client.GetDiagnostics(ctx, uri, source)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diagnostics: %w", err)
+	}
+
+	results := make([]DiagnosticResult, 0, len(diagnostics))
+	for _, diagnostic := range diagnostics {
+		results = append(results, DiagnosticResult{
+			Severity:  diagnostic.Severity.String(),
+			Line:      diagnostic.Range.Start.Line + 1,
+			Column:    diagnostic.Range.Start.Character + 1,
+			EndLine:   diagnostic.Range.End.Line + 1,
+			EndColumn: diagnostic.Range.End.Character + 1,
+			Code:      diagnostic.Code,
+			Source:    diagnostic.Source,
+			Message:   diagnostic.Message,
+		})
+	}
+
+	gt.logger.Info("Successfully retrieved diagnostics",
+		zap.String("filePath", filePath),
+		zap.Int("diagnosticCount", len(results)))
+
+	return results, nil
+}
+
+// initializeWorkspace opens the file in the gopls workspace. The overlay of a concurrent
+// call on the file is left in place.
+func (gt *GoplsTool) initializeWorkspace(ctx context.Context, client *GoplsClient, filePath string) error {
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Errorf("failed to read file content: %w", err)
+	}
+
+	// Convert file path to URI and open the document in gopls
+	uri := pathToURI(filePath)
+	if err := client.EnsureOpen(ctx, uri); err != nil {
+		return fmt.Errorf("failed to open document in gopls: %w", err)
+	}
+
+	return nil
+}
+
+// getDefinitionAtPosition gets the definition at a specific position using gopls
+func (gt *GoplsTool) getDefinitionAtPosition(ctx context.Context, client *GoplsClient, filePath string, position protocol.Position) (*protocol.Location, error) {
+	// Convert file path to URI
+	uri := pathToURI(filePath)
+
+	locations, err := client.GoToDefinition(ctx, uri, position.Line, position.Character)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("no definition found")
+	}
+
+	return &locations[0], nil
+}
+
+// getCodeAtLocation retrieves the lines of code covered by a location
+func (gt *GoplsTool) getCodeAtLocation(location *protocol.Location) (string, error) {
+	// Extract file path from URI
+	filePath := uriToPath(location.URI)
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	startLine := location.Range.Start.Line
+	endLine := location.Range.End.Line
+
+	if startLine >= len(lines) {
+		return "", fmt.Errorf("start line out of bounds")
+	}
+
+	if endLine >= len(lines) {
+		endLine = len(lines) - 1
+	}
+
+	// Extract the relevant lines
+	var result strings.Builder
+	for i := startLine; i <= endLine; i++ {
+		if i < len(lines) {
+			result.WriteString(lines[i])
+			if i < endLine {
+				result.WriteString("\n")
+			}
+		}
+	}
+
+	return result.String(), nil
+}
+
+// Close shuts down the gopls session if one was started
+func (gt *GoplsTool) Close() error {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+
+	if gt.goplsClient != nil {
+		err := gt.goplsClient.Close()
+		gt.goplsClient = nil
+		return err
+	}
+	return nil
+}
+
+// Reference is a single use of a symbol with a few surrounding lines. Line and Column are 1-based.
+type Reference struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Context string `json:"context"`
+}
+
+// FileReferences are the references found in one file
+type FileReferences struct {
+	Path       string      `json:"path"`
+	IsTest     bool        `json:"is_test,omitempty"`
+	References []Reference `json:"references"`
+}
+
+// PackageReferences are the references found in the files of one package
+type PackageReferences struct {
+	Package   string           `json:"package"`
+	Directory string           `json:"directory"`
+	Files     []FileReferences `json:"files"`
+}
+
+// ReferencesResult lists every reference to a symbol across the workspace, grouped by package and file
+type ReferencesResult struct {
+	Symbol     string              `json:"symbol"`
+	Definition string              `json:"definition"`
+	Total      int                 `json:"total"`
+	Packages   []PackageReferences `json:"packages"`
+}
+
+// FindReferences finds the references to a symbol resolved from filePath and returns each with
+// contextLines lines of surrounding code
+func (gt *GoplsTool) FindReferences(ctx context.Context, filePath, symbol string, contextLines int) (*ReferencesResult, error) {
+	gt.logger.Debug("Finding references with gopls",
+		zap.String("filePath", filePath),
+		zap.String("symbol", symbol))
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gt.initializeWorkspace(ctx, client, filePath); err != nil {
+		return nil, fmt.Errorf("failed to initialize workspace: %w", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	match, err := resolveSymbol(filePath, content, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	locations, err := client.FindReferences(ctx, pathToURI(match.File), match.Position.Line, match.Position.Character, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find references: %w", err)
+	}
+
+	result := &ReferencesResult{
+		Symbol:     match.Name,
+		Definition: fmt.Sprintf("%s:%d:%d", match.File, match.Position.Line+1, match.Position.Character+1),
+		Total:      len(locations),
+		Packages:   groupReferences(locations, contextLines),
+	}
+
+	gt.logger.Info("Successfully found references",
+		zap.String("symbol", match.Name),
+		zap.Int("referenceCount", result.Total),
+		zap.Int("packageCount", len(result.Packages)))
+
+	return result, nil
+}
+
+// groupReferences groups reference locations by package directory and file, sorted by path and line
+func groupReferences(locations []protocol.Location, contextLines int) []PackageReferences {
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].URI != locations[j].URI {
+			return locations[i].URI < locations[j].URI
+		}
+		if locations[i].Range.Start.Line != locations[j].Range.Start.Line {
+			return locations[i].Range.Start.Line < locations[j].Range.Start.Line
+		}
+		return locations[i].Range.Start.Character < locations[j].Range.Start.Character
+	})
+
+	var packages []PackageReferences
+	fileLines := make(map[string][]string)
+
+	for _, location := range locations {
+		path := uriToPath(location.URI)
+		dir := filepath.Dir(path)
+
+		if len(packages) == 0 || packages[len(packages)-1].Directory != dir {
+			packages = append(packages, PackageReferences{
+				Package:   packageName(path),
+				Directory: dir,
+			})
+		}
+		pkg := &packages[len(packages)-1]
+
+		if len(pkg.Files) == 0 || pkg.Files[len(pkg.Files)-1].Path != path {
+			pkg.Files = append(pkg.Files, FileReferences{
+				Path:   path,
+				IsTest: strings.HasSuffix(path, "_test.go"),
+			})
+		}
+		file := &pkg.Files[len(pkg.Files)-1]
+
+		lines, ok := fileLines[path]
+		if !ok {
+			if content, err := os.ReadFile(path); err == nil {
+				lines = strings.Split(string(content), "\n")
+			}
+			fileLines[path] = lines
+		}
+
+		file.References = append(file.References, Reference{
+			Line:    location.Range.Start.Line + 1,
+			Column:  location.Range.Start.Character + 1,
+			Context: contextSnippet(lines, location.Range.Start.Line, contextLines),
+		})
+	}
+
+	return packages
+}
+
+// contextSnippet returns the lines around a 0-based line, prefixed with their 1-based numbers.
+// The referencing line is marked with '>'.
+func contextSnippet(lines []string, line, contextLines int) string {
+	if line >= len(lines) {
+		return ""
+	}
+
+	start := max(line-contextLines, 0)
+	end := min(line+contextLines, len(lines)-1)
+
+	var snippet strings.Builder
+	for i := start; i <= end; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		snippet.WriteString(fmt.Sprintf("%s%5d | %s", marker, i+1, lines[i]))
+		if i < end {
+			snippet.WriteString("\n")
+		}
+	}
+	return snippet.String()
+}
+
+// packageName returns the name in the package clause of a Go file
+func packageName(filePath string) string {
+	file, err := parser.ParseFile(token.NewFileSet(), filePath, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	return file.Name.Name
+}
+
+// HoverResult is the type and doc information gopls shows for a symbol. Line and Column are 1-based.
+type HoverResult struct {
+	Symbol   string `json:"symbol,omitempty"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Markdown string `json:"markdown,omitempty"` // raw hover text, only set when it could not be parsed
+	HoverInfo
+}
+
+// symbolTarget is the identifier an operation applies to, given either as a symbol or as a position
+type symbolTarget struct {
+	symbol   string // resolved symbol name, empty for positions
+	file     string
+	position protocol.Position
+	line     int // 1-based
+	column   int // 1-based byte column
+}
+
+// locateTarget finds the identifier named by symbol in the package of filePath or, when
+// position is set, the identifier at "file:line:column" or "line:column" in filePath
+func locateTarget(filePath, symbol, position string) (*symbolTarget, error) {
+	if position != "" {
+		file, line, column, err := parseFilePosition(position, filePath)
+		if err != nil {
+			return nil, err
+		}
+		if file, err = filepath.Abs(file); err != nil {
+			return nil, fmt.Errorf("invalid file path: %w", err)
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+
+		return &symbolTarget{
+			file:     file,
+			position: lineColumnToPosition(content, line, column),
+			line:     line,
+			column:   column,
+		}, nil
+	}
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	match, err := resolveSymbol(filePath, content, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	matchContent, err := os.ReadFile(match.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	lineStart := positionToOffset(matchContent, protocol.Position{Line: match.Position.Line})
+	return &symbolTarget{
+		symbol:   match.Name,
+		file:     match.File,
+		position: match.Position,
+		line:     match.Position.Line + 1,
+		column:   positionToOffset(matchContent, match.Position) - lineStart + 1,
+	}, nil
+}
+
+// Hover returns the hover information for a symbol resolved from filePath, or for the
+// identifier at position, given as "file:line:column" or "line:column" in filePath
+func (gt *GoplsTool) Hover(ctx context.Context, filePath, symbol, position string) (*HoverResult, error) {
+	gt.logger.Debug("Requesting hover with gopls",
+		zap.String("filePath", filePath),
+		zap.String("symbol", symbol),
+		zap.String("position", position))
+
+	target, err := locateTarget(filePath, symbol, position)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &HoverResult{
+		Symbol: target.symbol,
+		File:   target.file,
+		Line:   target.line,
+		Column: target.column,
+	}
+
+	client, err := gt.acquire(ctx, result.File)
+	if err != nil {
+		return nil, err
+	}
+
+	hover, err := client.GetHover(ctx, pathToURI(target.file), target.position.Line, target.position.Character)
+	if err != nil {
+		return nil, err
+	}
+
+	result.HoverInfo = parseHoverMarkdown(hover.Contents.Value)
+	if result.Signature == "" && result.Doc == "" {
+		result.Markdown = hover.Contents.Value
+	}
+
+	// Workspace and unexported symbols have no pkg.go.dev link, use the package declaring them
+	if result.PackagePath == "" {
+		definitionPath := target.file
+		if target.symbol == "" {
+			definitionPath = ""
+			locations, err := client.GoToDefinition(ctx, pathToURI(target.file), target.position.Line, target.position.Character)
+			if err != nil {
+				gt.logger.Debug("Failed to locate the hovered definition", zap.Error(err))
+			} else if len(locations) > 0 {
+				definitionPath = uriToPath(locations[0].URI)
+			}
+		}
+		if definitionPath != "" {
+			result.PackagePath = importPath(filepath.Dir(definitionPath))
+		}
+	}
+
+	gt.logger.Info("Successfully retrieved hover information",
+		zap.String("file", result.File),
+		zap.Int("line", result.Line),
+		zap.String("packagePath", result.PackagePath))
+
+	return result, nil
+}
+
+// TypeInfo describes a type or method taking part in an implementation relation
+type TypeInfo struct {
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	Package     string   `json:"package"`
+	PackagePath string   `json:"package_path,omitempty"`
+	File        string   `json:"file"`
+	Line        int      `json:"line"` // 1-based
+	Methods     []string `json:"methods,omitempty"`
+	Mock        string   `json:"mock,omitempty"` // name of the mockery mock declared for an interface, e.g. MockReader
+}
+
+// ImplementationsResult relates a symbol to the types implementing it or the interfaces it implements
+type ImplementationsResult struct {
+	Symbol    TypeInfo   `json:"symbol"`
+	Direction string     `json:"direction"` // "implementations" for an interface, "interfaces" for a concrete type
+	Types     []TypeInfo `json:"types"`
+}
+
+// FindImplementations finds the concrete types implementing an interface, or the interfaces
+// a concrete type satisfies, for a symbol resolved from filePath
+func (gt *GoplsTool) FindImplementations(ctx context.Context, filePath, symbol string) (*ImplementationsResult, error) {
+	gt.logger.Debug("Finding implementations with gopls",
+		zap.String("filePath", filePath),
+		zap.String("symbol", symbol))
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gt.initializeWorkspace(ctx, client, filePath); err != nil {
+		return nil, fmt.Errorf("failed to initialize workspace: %w", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	match, err := resolveSymbol(filePath, content, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := gt.getDefinitionAtPosition(ctx, client, match.File, match.Position)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get definition: %w", err)
+	}
+
+	result := &ImplementationsResult{
+		Symbol:    describeTypeAt(*location),
+		Direction: "interfaces",
+		Types:     []TypeInfo{},
+	}
+	if result.Symbol.Kind == "interface" || result.Symbol.Kind == "interface_method" {
+		result.Direction = "implementations"
+	}
+
+	if err := client.EnsureOpen(ctx, location.URI); err != nil {
+		return nil, err
+	}
+
+	locations, err := client.FindImplementations(ctx, location.URI, location.Range.Start.Line, location.Range.Start.Character)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find implementations: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, implementation := range locations {
+		info := describeTypeAt(implementation)
+		key := fmt.Sprintf("%s:%d", info.File, info.Line)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result.Types = append(result.Types, info)
+	}
+
+	// Only name the mocks the workspace actually declares
+	gt.setMock(ctx, client, &result.Symbol)
+	for i := range result.Types {
+		gt.setMock(ctx, client, &result.Types[i])
+	}
+
+	sort.Slice(result.Types, func(i, j int) bool {
+		if result.Types[i].PackagePath != result.Types[j].PackagePath {
+			return result.Types[i].PackagePath < result.Types[j].PackagePath
+		}
+		return result.Types[i].Name < result.Types[j].Name
+	})
+
+	gt.logger.Info("Successfully found implementations",
+		zap.String("symbol", result.Symbol.Name),
+		zap.String("direction", result.Direction),
+		zap.Int("count", len(result.Types)))
+
+	return result, nil
+}
+
+// describeTypeAt describes the declaration at a location with its method set
+func describeTypeAt(location protocol.Location) TypeInfo {
+	path := uriToPath(location.URI)
+	info := TypeInfo{
+		Package:     packageName(path),
+		PackagePath: importPath(filepath.Dir(path)),
+		File:        path,
+		Line:        location.Range.Start.Line + 1,
+	}
+
+	declaration, err := enclosingDeclaration(path, location.Range.Start)
+	if err != nil {
+		return info
+	}
+
+	info.Name = declaration.Name
+	info.Kind = declaration.Kind
+	info.Methods = declaration.Methods
+	if declaration.Kind == "method" {
+		info.Methods = []string{declaration.Signature}
+	}
+
+	return info
+}
+
+// setMock sets the mockery mock of an interface outside the standard library, when the
+// workspace declares one
+func (gt *GoplsTool) setMock(ctx context.Context, client *GoplsClient, info *TypeInfo) {
+	if info.Kind != "interface" || isStandardLibrary(info.File) {
+		return
+	}
+
+	symbols, err := client.WorkspaceSymbols(ctx, "Mock"+info.Name)
+	if err != nil {
+		gt.logger.Debug("Failed to search the mock of an interface",
+			zap.String("interface", info.Name),
+			zap.Error(err))
+		return
+	}
+	info.Mock = declaredMock(info.Name, symbols)
+}
+
+// declaredMock returns the name of the mockery mock of an interface among workspace symbols,
+// or "" when there is none
+func declaredMock(name string, symbols []protocol.SymbolInformation) string {
+	mock := "Mock" + name
+	for _, symbol := range symbols {
+		if symbol.Kind != protocol.SymbolKindStruct && symbol.Kind != protocol.SymbolKindClass {
+			continue
+		}
+		// gopls qualifies names with their package, e.g. mocks.MockReader
+		if symbol.Name == mock || strings.HasSuffix(symbol.Name, "."+mock) {
+			return mock
+		}
+	}
+	return ""
+}
+
+// CallNode is a function in a call tree. For outgoing trees Calls are its callees, for
+// incoming trees its callers. CallSites are the 1-based lines of the calls in the caller.
+type CallNode struct {
+	Name        string      `json:"name"`
+	Kind        string      `json:"kind"`
+	Signature   string      `json:"signature,omitempty"`
+	PackagePath string      `json:"package_path,omitempty"`
+	File        string      `json:"file"`
+	Line        int         `json:"line"`
+	CallSites   []int       `json:"call_sites,omitempty"`
+	Visited     bool        `json:"visited,omitempty"` // already expanded elsewhere in the tree, e.g. recursion
+	Calls       []*CallNode `json:"calls,omitempty"`
+}
+
+// CallHierarchyResult is a depth-limited call tree rooted at a function
+type CallHierarchyResult struct {
+	Direction string    `json:"direction"` // "outgoing" or "incoming"
+	Depth     int       `json:"depth"`
+	Root      *CallNode `json:"root"`
+	// Dependencies are the functions called directly by the root outside the standard
+	// library: the calls a unit test of the root has to mock. Only set for outgoing trees.
+	Dependencies []string `json:"dependencies,omitempty"`
+	// Truncated is set when the tree was cut short after expanding maxCallNodes functions
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// CallHierarchy builds the tree of the functions a function calls (direction "outgoing")
+// or of the functions calling it ("incoming"), up to depth levels
+func (gt *GoplsTool) CallHierarchy(ctx context.Context, filePath, symbol, direction string, depth int) (*CallHierarchyResult, error) {
+	gt.logger.Debug("Building call hierarchy with gopls",
+		zap.String("filePath", filePath),
+		zap.String("symbol", symbol),
+		zap.String("direction", direction),
+		zap.Int("depth", depth))
+
+	if direction != "outgoing" && direction != "incoming" {
+		return nil, fmt.Errorf("invalid direction %q: must be 'outgoing' or 'incoming'", direction)
+	}
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gt.initializeWorkspace(ctx, client, filePath); err != nil {
+		return nil, fmt.Errorf("failed to initialize workspace: %w", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	match, err := resolveSymbol(filePath, content, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	uri := pathToURI(match.File)
+	if err := client.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
+	}
+
+	items, err := client.PrepareCallHierarchy(ctx, uri, match.Position.Line, match.Position.Character)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare call hierarchy: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("symbol %q is not a function or method", symbol)
+	}
+
+	root := newCallNode(items[0], nil)
+	expanded, truncated, err := gt.expandCalls(ctx, items[0], root, depth, func(item protocol.CallHierarchyItem) ([]hierarchyCall, error) {
+		return hierarchyCalls(ctx, client, item, direction)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CallHierarchyResult{
+		Direction: direction,
+		Depth:     depth,
+		Root:      root,
+		Truncated: truncated,
+	}
+
+	if direction == "outgoing" {
+		seen := make(map[string]bool)
+		for _, callee := range root.Calls {
+			if isStandardLibrary(callee.File) {
+				continue
+			}
+			name := callee.Name
+			if callee.PackagePath != "" {
+				name = callee.PackagePath + "." + name
+			}
+			if !seen[name] {
+				seen[name] = true
+				result.Dependencies = append(result.Dependencies, name)
+			}
+		}
+	}
+
+	gt.logger.Info("Successfully built call hierarchy",
+		zap.String("symbol", root.Name),
+		zap.String("direction", direction),
+		zap.Int("expandedCount", expanded),
+		zap.Bool("truncated", truncated))
+
+	return result, nil
+}
+
+// maxCallNodes caps the number of functions CallHierarchy expands, each costing one gopls request
+const maxCallNodes = 100
+
+// hierarchyCall is a callee or caller of a function with the ranges of the calls
+type hierarchyCall struct {
+	item   protocol.CallHierarchyItem
+	ranges []protocol.Range
+}
+
+// hierarchyCalls returns the callees ("outgoing") or the callers ("incoming") of item
+func hierarchyCalls(ctx context.Context, client *GoplsClient, item protocol.CallHierarchyItem, direction string) ([]hierarchyCall, error) {
+	var calls []hierarchyCall
+
+	if direction == "outgoing" {
+		outgoing, err := client.OutgoingCalls(ctx, item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get outgoing calls: %w", err)
+		}
+		for _, c := range outgoing {
+			calls = append(calls, hierarchyCall{item: c.To, ranges: c.FromRanges})
+		}
+		return calls, nil
+	}
+
+	incoming, err := client.IncomingCalls(ctx, item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get incoming calls: %w", err)
+	}
+	for _, c := range incoming {
+		calls = append(calls, hierarchyCall{item: c.From, ranges: c.FromRanges})
+	}
+	return calls, nil
+}
+
+// expandCalls adds the calls returned by fetch to the tree rooted at item, level by level,
+// until depth levels are expanded or maxCallNodes functions were. Each function is expanded
+// once; later occurrences are marked as visited. It returns the number of expanded functions
+// and whether the budget cut the tree short.
+func (gt *GoplsTool) expandCalls(ctx context.Context, item protocol.CallHierarchyItem, root *CallNode, depth int, fetch func(protocol.CallHierarchyItem) ([]hierarchyCall, error)) (int, bool, error) {
+	type pendingNode struct {
+		item protocol.CallHierarchyItem
+		node *CallNode
+	}
+
+	visited := make(map[string]bool)
+	firstVisit := func(pending pendingNode) bool {
+		key := fmt.Sprintf("%s:%d:%d", pending.item.URI, pending.item.SelectionRange.Start.Line, pending.item.SelectionRange.Start.Character)
+		if visited[key] {
+			pending.node.Visited = true
+			return false
+		}
+		visited[key] = true
+		return true
+	}
+
+	expanded := 0
+	level := []pendingNode{{item: item, node: root}}
+	for ; depth > 0 && len(level) > 0; depth-- {
+		var next []pendingNode
+		for _, pending := range level {
+			if err := ctx.Err(); err != nil {
+				return expanded, false, err
+			}
+			if !firstVisit(pending) {
+				continue
+			}
+			if expanded == maxCallNodes {
+				return expanded, true, nil
+			}
+			expanded++
+
+			calls, err := fetch(pending.item)
+			if err != nil {
+				gt.logger.Warn("Failed to expand call hierarchy", zap.String("function", pending.item.Name), zap.Error(err))
+				continue
+			}
+			for _, c := range calls {
+				child := newCallNode(c.item, c.ranges)
+				pending.node.Calls = append(pending.node.Calls, child)
+				next = append(next, pendingNode{item: c.item, node: child})
+			}
+		}
+		level = next
+	}
+
+	// The last level is not expanded, only marked where it repeats a function
+	for _, pending := range level {
+		firstVisit(pending)
+	}
+
+	return expanded, false, nil
+}
+
+// newCallNode describes a call hierarchy item, qualifying methods with their receiver type
+func newCallNode(item protocol.CallHierarchyItem, callRanges []protocol.Range) *CallNode {
+	path := uriToPath(item.URI)
+	node := &CallNode{
+		Name:        item.Name,
+		Kind:        item.Kind.String(),
+		PackagePath: importPath(filepath.Dir(path)),
+		File:        path,
+		Line:        item.SelectionRange.Start.Line + 1,
+	}
+
+	if declaration, err := enclosingDeclaration(path, item.SelectionRange.Start); err == nil &&
+		(declaration.Kind == "func" || declaration.Kind == "method") {
+		node.Name = declaration.Name
+		node.Kind = declaration.Kind
+		node.Signature = declaration.Signature
+	}
+
+	for _, r := range callRanges {
+		line := r.Start.Line + 1
+		if len(node.CallSites) == 0 || node.CallSites[len(node.CallSites)-1] != line {
+			node.CallSites = append(node.CallSites, line)
+		}
+	}
+
+	return node
+}
+
+// symbolKindFilters maps the kind filters of search_symbols to LSP symbol kinds
+var symbolKindFilters = map[string][]protocol.SymbolKind{
+	"func":      {protocol.SymbolKindFunction},
+	"method":    {protocol.SymbolKindMethod},
+	"struct":    {protocol.SymbolKindStruct},
+	"interface": {protocol.SymbolKindInterface},
+	"type":      {protocol.SymbolKindClass, protocol.SymbolKindStruct, protocol.SymbolKindInterface, protocol.SymbolKindTypeParameter},
+	"field":     {protocol.SymbolKindField},
+	"const":     {protocol.SymbolKindConstant},
+	"var":       {protocol.SymbolKindVariable},
+}
+
+// maxSymbolResults caps the number of symbols returned by SearchSymbols
+const maxSymbolResults = 50
+
+// SymbolSearchMatch is a symbol found in the workspace. Path and Symbol can be passed as is
+// to the code_definitions action. Line and Column are 1-based.
+type SymbolSearchMatch struct {
+	Symbol      string `json:"symbol"`
+	Kind        string `json:"kind"`
+	Package     string `json:"package"`
+	PackagePath string `json:"package_path,omitempty"`
+	Path        string `json:"path"`
+	Line        int    `json:"line"`
+	Column      int    `json:"column"`
+}
+
+// SymbolSearchResult lists the symbols matching a query, best matches first
+type SymbolSearchResult struct {
+	Query     string              `json:"query"`
+	Total     int                 `json:"total"`
+	Truncated bool                `json:"truncated,omitempty"`
+	Symbols   []SymbolSearchMatch `json:"symbols"`
+}
+
+// SearchSymbols searches the workspace of filePath for symbols fuzzily matching query.
+// kinds restricts the symbol kinds (func, method, struct, interface, type, field, const, var)
+// and pkg the package, by name, import path or import path suffix. Without filePath the
+// configured workspace root or the current directory is searched.
+func (gt *GoplsTool) SearchSymbols(ctx context.Context, filePath, query string, kinds []string, pkg string) (*SymbolSearchResult, error) {
+	gt.logger.Debug("Searching workspace symbols with gopls",
+		zap.String("filePath", filePath),
+		zap.String("query", query),
+		zap.Strings("kinds", kinds),
+		zap.String("package", pkg))
+
+	if filePath == "" {
+		gt.mutex.Lock()
+		filePath = gt.workspaceRoot
+		gt.mutex.Unlock()
+	}
+	if filePath == "" {
+		filePath = "."
+	}
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path: %w", err)
+	}
+
+	allowedKinds := make(map[protocol.SymbolKind]bool)
+	for _, kind := range kinds {
+		lspKinds, ok := symbolKindFilters[kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown symbol kind %q", kind)
+		}
+		for _, lspKind := range lspKinds {
+			allowedKinds[lspKind] = true
+		}
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, err := client.WorkspaceSymbols(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search workspace symbols: %w", err)
+	}
+
+	result := &SymbolSearchResult{
+		Query:   query,
+		Symbols: []SymbolSearchMatch{},
+	}
+
+	var matches []SymbolSearchMatch
+	for _, symbol := range symbols {
+		if len(allowedKinds) > 0 && !allowedKinds[symbol.Kind] {
+			continue
+		}
+
+		path := uriToPath(symbol.Location.URI)
+		name := packageName(path)
+		packagePath := importPath(filepath.Dir(path))
+		if pkg != "" && !matchesPackage(pkg, name, packagePath) {
+			continue
+		}
+
+		line := symbol.Location.Range.Start.Line + 1
+		column := symbol.Location.Range.Start.Character + 1
+		if content, err := os.ReadFile(path); err == nil {
+			start := symbol.Location.Range.Start
+			column = positionToOffset(content, start) - positionToOffset(content, protocol.Position{Line: start.Line}) + 1
+		}
+
+		matches = append(matches, SymbolSearchMatch{
+			Symbol:      strings.TrimPrefix(symbol.Name, name+"."),
+			Kind:        searchKindName(symbol.Kind),
+			Package:     name,
+			PackagePath: packagePath,
+			Path:        path,
+			Line:        line,
+			Column:      column,
+		})
+	}
+
+	// Keep the gopls ranking but list workspace symbols before those of the standard library
+	sort.SliceStable(matches, func(i, j int) bool {
+		return !isStandardLibrary(matches[i].Path) && isStandardLibrary(matches[j].Path)
+	})
+
+	result.Total = len(matches)
+	if len(matches) > maxSymbolResults {
+		matches = matches[:maxSymbolResults]
+		result.Truncated = true
+	}
+	result.Symbols = append(result.Symbols, matches...)
+
+	gt.logger.Info("Successfully searched workspace symbols",
+		zap.String("query", query),
+		zap.Int("matchCount", result.Total))
+
+	return result, nil
+}
+
+// matchesPackage reports whether a package filter names a package by name, import path or import path suffix
+func matchesPackage(filter, name, packagePath string) bool {
+	return filter == name || filter == packagePath || strings.HasSuffix(packagePath, "/"+filter)
+}
+
+// searchKindName returns the kind filter name of an LSP symbol kind
+func searchKindName(kind protocol.SymbolKind) string {
+	switch kind {
+	case protocol.SymbolKindFunction:
+		return "func"
+	case protocol.SymbolKindClass, protocol.SymbolKindTypeParameter:
+		return "type"
+	case protocol.SymbolKindConstant:
+		return "const"
+	case protocol.SymbolKindVariable:
+		return "var"
+	default:
+		return kind.String()
+	}
+}
+
+// FileOutline returns the outline of a Go file: its types with their fields and methods,
+// functions, consts and vars with their line ranges
+func (gt *GoplsTool) FileOutline(ctx context.Context, filePath string) (*FileOutline, error) {
+	gt.logger.Debug("Building file outline with gopls", zap.String("filePath", filePath))
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, err := client.DocumentSymbols(ctx, pathToURI(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document symbols: %w", err)
+	}
+
+	outline := &FileOutline{
+		Path:    filePath,
+		Package: packageName(filePath),
+		Lines:   strings.Count(string(content), "\n") + 1,
+	}
+	buildOutline(outline, symbols)
+
+	gt.logger.Info("Successfully built file outline",
+		zap.String("filePath", filePath),
+		zap.Int("typeCount", len(outline.Types)),
+		zap.Int("functionCount", len(outline.Functions)))
+
+	return outline, nil
+}
+
+// DescribeType describes the type named by symbol, or the type of the variable, field or
+// parameter it names: fields with embedding and tags, value and pointer method sets and constructors
+func (gt *GoplsTool) DescribeType(ctx context.Context, filePath, symbol string) (*TypeDescription, error) {
+	gt.logger.Debug("Describing type with gopls",
+		zap.String("filePath", filePath),
+		zap.String("symbol", symbol))
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gt.initializeWorkspace(ctx, client, filePath); err != nil {
+		return nil, fmt.Errorf("failed to initialize workspace: %w", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	match, err := resolveSymbol(filePath, content, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	uri := pathToURI(match.File)
+	if err := client.EnsureOpen(ctx, uri); err != nil {
+		return nil, err
+	}
+
+	var location *protocol.Location
+	switch match.Kind {
+	case "struct", "interface", "type":
+		location = &protocol.Location{URI: uri, Range: protocol.Range{Start: match.Position, End: match.Position}}
+	default:
+		// Imported types, values and fields: let gopls find the declaration of their type
+		locations, err := client.GoToTypeDefinition(ctx, uri, match.Position.Line, match.Position.Character)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get type definition: %w", err)
+		}
+		if len(locations) == 0 {
+			return nil, fmt.Errorf("no type definition found for %q", symbol)
+		}
+		location = &locations[0]
+	}
+
+	// Embedded types of other packages are found through gopls
+	resolve := func(file string, position protocol.Position) (string, error) {
+		uri := pathToURI(file)
+		if err := client.EnsureOpen(ctx, uri); err != nil {
+			return "", err
+		}
+		locations, err := client.GoToDefinition(ctx, uri, position.Line, position.Character)
+		if err != nil {
+			return "", err
+		}
+		if len(locations) == 0 {
+			return "", fmt.Errorf("no definition found at %s:%d:%d", file, position.Line+1, position.Character+1)
+		}
+		return uriToPath(locations[0].URI), nil
+	}
+
+	description, err := describeTypeDeclaration(uriToPath(location.URI), location.Range.Start, resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	gt.logger.Info("Successfully described type",
+		zap.String("type", description.Name),
+		zap.Int("fieldCount", len(description.Fields)),
+		zap.Int("methodCount", len(description.ValueMethods)+len(description.PointerMethods)),
+		zap.Int("promotedCount", len(description.PromotedMethods)),
+		zap.Strings("unresolvedEmbedded", description.UnresolvedEmbedded))
+
+	return description, nil
+}
+
+// EditedFile is a file changed by a refactoring
+type EditedFile struct {
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"`
+	Action  string `json:"action"` // modified, created, renamed or deleted
+	Diff    string `json:"diff"`   // unified diff of the change
+}
+
+// EditResult reports the files changed by a refactoring, or that would be changed in a dry run
+type EditResult struct {
+	DryRun  bool         `json:"dry_run"`
+	Applied bool         `json:"applied"`
+	Files   []EditedFile `json:"files"`
+}
+
+// finishEdits writes the accumulated edits to disk and tells gopls about the changed files,
+// or only describes them in a dry run
+func (gt *GoplsTool) finishEdits(client *GoplsClient, session *editSession, dryRun bool) (*EditResult, error) {
+	files := session.files()
+
+	result := &EditResult{DryRun: dryRun, Files: []EditedFile{}}
+	for _, change := range files {
+		result.Files = append(result.Files, EditedFile{
+			Path:    change.path,
+			OldPath: change.oldPath,
+			Action:  change.action(),
+			Diff:    change.diff(),
+		})
+	}
+
+	if dryRun || len(files) == 0 {
+		return result, nil
+	}
+
+	if err := session.commit(); err != nil {
+		return nil, err
+	}
+	result.Applied = true
+
+	var events []protocol.FileEvent
+	for _, change := range files {
+		uri := pathToURI(change.path)
+		switch {
+		case change.deleted:
+			events = append(events, protocol.FileEvent{URI: uri, Type: protocol.FileDeleted})
+			client.DidClose(uri)
+		case change.oldPath != "":
+			oldURI := pathToURI(change.oldPath)
+			events = append(events,
+				protocol.FileEvent{URI: oldURI, Type: protocol.FileDeleted},
+				protocol.FileEvent{URI: uri, Type: protocol.FileCreated})
+			client.DidClose(oldURI)
+		case !change.existed:
+			events = append(events, protocol.FileEvent{URI: uri, Type: protocol.FileCreated})
+		default:
+			events = append(events, protocol.FileEvent{URI: uri, Type: protocol.FileChanged})
+			// The file is written, so gopls is synced with it even if the call was cancelled
+			if client.IsOpen(uri) {
+				if err := client.DidOpen(context.Background(), uri, "go", string(change.after)); err != nil {
+					gt.logger.Warn("Failed to sync edited document with gopls", zap.String("uri", uri), zap.Error(err))
+				}
+			}
+		}
+	}
+
+	if err := client.DidChangeWatchedFiles(events); err != nil {
+		gt.logger.Warn("Failed to notify gopls of edited files", zap.Error(err))
+	}
+
+	gt.logger.Info("Applied workspace edits", zap.Int("fileCount", len(files)))
+	return result, nil
+}
+
+// Rename renames the identifier named by symbol, or at position, everywhere in the workspace
+func (gt *GoplsTool) Rename(ctx context.Context, filePath, symbol, position, newName string, dryRun bool) (*EditResult, error) {
+	gt.logger.Debug("Renaming with gopls",
+		zap.String("filePath", filePath),
+		zap.String("symbol", symbol),
+		zap.String("position", position),
+		zap.String("newName", newName),
+		zap.Bool("dryRun", dryRun))
+
+	target, err := locateTarget(filePath, symbol, position)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gt.acquire(ctx, target.file)
+	if err != nil {
+		return nil, err
+	}
+
+	edit, err := client.Rename(ctx, pathToURI(target.file), target.position.Line, target.position.Character, newName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename: %w", err)
+	}
+
+	session := newEditSession()
+	if err := session.add(*edit); err != nil {
+		return nil, err
+	}
+
+	return gt.finishEdits(client, session, dryRun)
+}
+
+// CodeActionInfo is a code action offered by gopls for a range
+type CodeActionInfo struct {
+	Title       string   `json:"title"`
+	Kind        string   `json:"kind,omitempty"`
+	Preferred   bool     `json:"preferred,omitempty"`
+	Diagnostics []string `json:"diagnostics,omitempty"` // messages of the diagnostics the action fixes
+}
+
+// codeActionRange returns the LSP range from startLine:startColumn to endLine:endColumn,
+// all 1-based. A zero column selects from the start or to the end of the line.
+func codeActionRange(content []byte, startLine, startColumn, endLine, endColumn int) protocol.Range {
+	if endLine < startLine {
+		endLine = startLine
+	}
+
+	start := lineColumnToPosition(content, startLine, max(startColumn, 1))
+	var end protocol.Position
+	if endColumn > 0 {
+		end = lineColumnToPosition(content, endLine, endColumn)
+	} else {
+		end = lineColumnToPosition(content, endLine, len(content)+1)
+	}
+	return protocol.Range{Start: start, End: end}
+}
+
+// codeActions requests the code actions for a range, passing the diagnostics gopls published
+// for it so quick fixes are included
+func (gt *GoplsTool) codeActions(ctx context.Context, filePath string, startLine, startColumn, endLine, endColumn int, kinds []string) (*GoplsClient, []protocol.CodeAction, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	client, err := gt.acquire(ctx, filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uri := pathToURI(filePath)
+	if err := client.EnsureOpen(ctx, uri); err != nil {
+		return nil, nil, err
+	}
+
+	rng := codeActionRange(content, startLine, startColumn, endLine, endColumn)
+
+	var diagnostics []protocol.Diagnostic
+	for _, diagnostic := range client.cachedDiagnostics(uri) {
+		if diagnostic.Range.Start.Line <= rng.End.Line && diagnostic.Range.End.Line >= rng.Start.Line {
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+
+	actions, err := client.CodeActions(ctx, uri, rng, diagnostics, kinds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get code actions: %w", err)
+	}
+
+	return client, actions, nil
+}
+
+// ListCodeActions lists the code actions gopls offers for a range of lines
+func (gt *GoplsTool) ListCodeActions(ctx context.Context, filePath string, startLine, startColumn, endLine, endColumn int, kinds []string) ([]CodeActionInfo, error) {
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	_, actions, err := gt.codeActions(ctx, filePath, startLine, startColumn, endLine, endColumn, kinds)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]CodeActionInfo, 0, len(actions))
+	for _, action := range actions {
+		info := CodeActionInfo{
+			Title:     action.Title,
+			Kind:      action.Kind,
+			Preferred: action.IsPreferred,
+		}
+		for _, diagnostic := range action.Diagnostics {
+			info.Diagnostics = append(info.Diagnostics, diagnostic.Message)
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// ApplyCodeAction applies the code action whose title matches title, exactly or as a case
+// insensitive substring, either through its workspace edit or by executing its command
+func (gt *GoplsTool) ApplyCodeAction(ctx context.Context, filePath string, startLine, startColumn, endLine, endColumn int, kinds []string, title string, dryRun bool) (*EditResult, error) {
+	gt.logger.Debug("Applying code action with gopls",
+		zap.String("filePath", filePath),
+		zap.Int("startLine", startLine),
+		zap.Int("endLine", endLine),
+		zap.String("title", title),
+		zap.Bool("dryRun", dryRun))
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path: %w", err)
+	}
+
+	client, actions, err := gt.codeActions(ctx, filePath, startLine, startColumn, endLine, endColumn, kinds)
+	if err != nil {
+		return nil, err
+	}
+
+	action, err := pickCodeAction(actions, title)
+	if err != nil {
+		return nil, err
+	}
+
+	session := newEditSession()
+	if action.Edit != nil {
+		if err := session.add(*action.Edit); err != nil {
+			return nil, err
+		}
+	}
+
+	if action.Command != nil {
+		// Other commands may write files or run go generate, go mod tidy... themselves
+		if dryRun && !editOnlyCommands[action.Command.Command] {
+			return nil, fmt.Errorf("code action %q runs the command %s and cannot be previewed, apply it without dry_run", action.Title, action.Command.Command)
+		}
+
+		// gopls applies the result of most refactorings through workspace/applyEdit while the
+		// command runs; collect those edits so they can be previewed or written at once
+		_, err := client.ExecuteCommand(ctx, action.Command.Command, action.Command.Arguments, session.add)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute command %s: %w", action.Command.Command, err)
+		}
+	}
+
+	result, err := gt.finishEdits(client, session, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	gt.logger.Info("Code action processed",
+		zap.String("title", action.Title),
+		zap.Int("fileCount", len(result.Files)),
+		zap.Bool("applied", result.Applied))
+
+	return result, nil
+}
+
+// editOnlyCommands are the gopls commands whose only effect is the workspace/applyEdit
+// request they send, so running them in a dry run does not touch the files
+var editOnlyCommands = map[string]bool{
+	"gopls.apply_fix": true,
+}
+
+// pickCodeAction selects the action with the given title, or the only action whose title contains it
+func pickCodeAction(actions []protocol.CodeAction, title string) (*protocol.CodeAction, error) {
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("no code actions available for this range")
+	}
+
+	for i := range actions {
+		if actions[i].Title == title {
+			return &actions[i], nil
+		}
+	}
+
+	var matches []*protocol.CodeAction
+	var titles []string
+	for i := range actions {
+		titles = append(titles, actions[i].Title)
+		if strings.Contains(strings.ToLower(actions[i].Title), strings.ToLower(title)) {
+			matches = append(matches, &actions[i])
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return nil, fmt.Errorf("no code action matches %q, available: %s", title, strings.Join(titles, "; "))
+	default:
+		var matching []string
+		for _, match := range matches {
+			matching = append(matching, match.Title)
+		}
+		return nil, fmt.Errorf("code action %q is ambiguous, matches: %s", title, strings.Join(matching, "; "))
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gemini-tool/protocol"
+
+	"go.uber.org/zap"
+)
+
+func TestContextSnippet(t *testing.T) {
+	lines := strings.Split("package sample\n\nfunc run() {\n\tstart()\n}", "\n")
+
+	tests := []struct {
+		name         string
+		line         int
+		contextLines int
+		want         string
+	}{
+		{
+			name:         "surrounding lines",
+			line:         3,
+			contextLines: 1,
+			want:         "     3 | func run() {\n>    4 | \tstart()\n     5 | }",
+		},
+		{
+			name:         "no context",
+			line:         2,
+			contextLines: 0,
+			want:         ">    3 | func run() {",
+		},
+		{
+			name:         "first line",
+			line:         0,
+			contextLines: 2,
+			want:         ">    1 | package sample\n     2 | \n     3 | func run() {",
+		},
+		{
+			name:         "last line",
+			line:         4,
+			contextLines: 2,
+			want:         "     3 | func run() {\n     4 | \tstart()\n>    5 | }",
+		},
+		{
+			name:         "context larger than the file",
+			line:         1,
+			contextLines: 10,
+			want:         "     1 | package sample\n>    2 | \n     3 | func run() {\n     4 | \tstart()\n     5 | }",
+		},
+		{
+			name:         "line past the end",
+			line:         5,
+			contextLines: 2,
+			want:         "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contextSnippet(lines, tt.line, tt.contextLines); got != tt.want {
+				t.Errorf("contextSnippet(%d, %d) =\n%s\nwant\n%s", tt.line, tt.contextLines, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupReferences(t *testing.T) {
+	dir := t.TempDir()
+	client := filepath.Join(dir, "client", "client.go")
+	clientTest := filepath.Join(dir, "client", "client_test.go")
+	server := filepath.Join(dir, "server", "server.go")
+	for _, pkg := range []string{"client", "server"} {
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, client, "package client\n\nfunc Dial() {}\n\nvar _ = Dial\n")
+	writeTestFile(t, clientTest, "package client\n\nvar _ = Dial\n")
+	writeTestFile(t, server, "package server\n\nvar _ = client.Dial\n")
+
+	location := func(path string, line, character int) protocol.Location {
+		position := protocol.Position{Line: line, Character: character}
+		return protocol.Location{URI: pathToURI(path), Range: protocol.Range{Start: position, End: position}}
+	}
+
+	tests := []struct {
+		name         string
+		locations    []protocol.Location
+		contextLines int
+		want         []PackageReferences
+	}{
+		{
+			name: "grouped by package and file in line order",
+			locations: []protocol.Location{
+				location(server, 2, 15),
+				location(client, 4, 8),
+				location(clientTest, 2, 8),
+				location(client, 2, 5),
+			},
+			want: []PackageReferences{
+				{
+					Package:   "client",
+					Directory: filepath.Dir(client),
+					Files: []FileReferences{
+						{Path: client, References: []Reference{
+							{Line: 3, Column: 6, Context: ">    3 | func Dial() {}"},
+							{Line: 5, Column: 9, Context: ">    5 | var _ = Dial"},
+						}},
+						{Path: clientTest, IsTest: true, References: []Reference{
+							{Line: 3, Column: 9, Context: ">    3 | var _ = Dial"},
+						}},
+					},
+				},
+				{
+					Package:   "server",
+					Directory: filepath.Dir(server),
+					Files: []FileReferences{
+						{Path: server, References: []Reference{
+							{Line: 3, Column: 16, Context: ">    3 | var _ = client.Dial"},
+						}},
+					},
+				},
+			},
+		},
+		{
+			name:         "context lines",
+			locations:    []protocol.Location{location(clientTest, 2, 8)},
+			contextLines: 1,
+			want: []PackageReferences{{
+				Package:   "client",
+				Directory: filepath.Dir(clientTest),
+				Files: []FileReferences{{Path: clientTest, IsTest: true, References: []Reference{
+					{Line: 3, Column: 9, Context: "     2 | \n>    3 | var _ = Dial\n     4 | "},
+				}}},
+			}},
+		},
+		{
+			name:      "unreadable file has no context",
+			locations: []protocol.Location{location(filepath.Join(dir, "gone", "gone.go"), 0, 0)},
+			want: []PackageReferences{{
+				Directory: filepath.Join(dir, "gone"),
+				Files: []FileReferences{{
+					Path:       filepath.Join(dir, "gone", "gone.go"),
+					References: []Reference{{Line: 1, Column: 1}},
+				}},
+			}},
+		},
+		{
+			name: "no references",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := groupReferences(tt.locations, tt.contextLines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupReferences() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesPackage(t *testing.T) {
+	tests := []struct {
+		filter      string
+		name        string
+		packagePath string
+		want        bool
+	}{
+		{filter: "http", name: "http", packagePath: "net/http", want: true},
+		{filter: "net/http", name: "http", packagePath: "net/http", want: true},
+		{filter: "x/net/http", name: "http", packagePath: "golang.org/x/net/http", want: true},
+		{filter: "ttp", name: "http", packagePath: "net/http", want: false},
+		{filter: "net", name: "http", packagePath: "net/http", want: false},
+		{filter: "gemini-tool", name: "main", packagePath: "gemini-tool", want: true},
+		{filter: "main", name: "main", packagePath: "", want: true},
+		{filter: "protocol", name: "protocol", packagePath: "", want: true},
+		{filter: "tool", name: "main", packagePath: "gemini-tool", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter+" "+tt.packagePath, func(t *testing.T) {
+			if got := matchesPackage(tt.filter, tt.name, tt.packagePath); got != tt.want {
+				t.Errorf("matchesPackage(%q, %q, %q) = %t, want %t", tt.filter, tt.name, tt.packagePath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchKindName(t *testing.T) {
+	tests := []struct {
+		kind protocol.SymbolKind
+		want string
+	}{
+		{kind: protocol.SymbolKindFunction, want: "func"},
+		{kind: protocol.SymbolKindClass, want: "type"},
+		{kind: protocol.SymbolKindTypeParameter, want: "type"},
+		{kind: protocol.SymbolKindConstant, want: "const"},
+		{kind: protocol.SymbolKindVariable, want: "var"},
+		{kind: protocol.SymbolKindStruct, want: "struct"},
+		{kind: protocol.SymbolKindMethod, want: "method"},
+		{kind: protocol.SymbolKindInterface, want: "interface"},
+		{kind: protocol.SymbolKindField, want: "field"},
+		{kind: 0, want: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := searchKindName(tt.kind); got != tt.want {
+				t.Errorf("searchKindName(%d) = %q, want %q", tt.kind, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeclaredMock(t *testing.T) {
+	tests := []struct {
+		name    string
+		symbols []protocol.SymbolInformation
+		want    string
+	}{
+		{
+			name:    "mock struct",
+			symbols: []protocol.SymbolInformation{{Name: "MockReader", Kind: protocol.SymbolKindStruct}},
+			want:    "MockReader",
+		},
+		{
+			name:    "name qualified with its package",
+			symbols: []protocol.SymbolInformation{{Name: "mocks.MockReader", Kind: protocol.SymbolKindStruct}},
+			want:    "MockReader",
+		},
+		{
+			name: "other symbols matching the query",
+			symbols: []protocol.SymbolInformation{
+				{Name: "MockReaderFactory", Kind: protocol.SymbolKindStruct},
+				{Name: "NewMockReader", Kind: protocol.SymbolKindFunction},
+				{Name: "MockReader.Read", Kind: protocol.SymbolKindMethod},
+			},
+			want: "",
+		},
+		{
+			name: "no symbols",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := declaredMock("Reader", tt.symbols); got != tt.want {
+				t.Errorf("declaredMock() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandCalls(t *testing.T) {
+	item := func(name string) protocol.CallHierarchyItem {
+		return protocol.CallHierarchyItem{Name: name, Kind: protocol.SymbolKindFunction, URI: "file:///src/" + name + ".go"}
+	}
+	// graph lists the calls of each function; functions missing from it call nothing
+	fetchFrom := func(graph map[string][]string) func(protocol.CallHierarchyItem) ([]hierarchyCall, error) {
+		return func(caller protocol.CallHierarchyItem) ([]hierarchyCall, error) {
+			if caller.Name == "broken" {
+				return nil, errors.New("no package for file")
+			}
+			var calls []hierarchyCall
+			for _, callee := range graph[caller.Name] {
+				calls = append(calls, hierarchyCall{item: item(callee)})
+			}
+			return calls, nil
+		}
+	}
+
+	wide := make(map[string][]string)
+	for _, caller := range []string{"main", "f0", "f1", "f2", "f3", "f4", "f5"} {
+		for i := range 20 {
+			wide[caller] = append(wide[caller], fmt.Sprintf("f%d", i))
+		}
+	}
+	huge := make(map[string][]string)
+	for i := range 30 {
+		huge["main"] = append(huge["main"], fmt.Sprintf("f%d", i))
+		for j := range 30 {
+			huge[fmt.Sprintf("f%d", i)] = append(huge[fmt.Sprintf("f%d", i)], fmt.Sprintf("f%d_%d", i, j))
+		}
+	}
+
+	tests := []struct {
+		name          string
+		graph         map[string][]string
+		depth         int
+		cancelled     bool
+		want          string
+		wantExpanded  int
+		wantTruncated bool
+		wantErr       bool
+	}{
+		{
+			name:         "depth limits the tree",
+			graph:        map[string][]string{"main": {"run"}, "run": {"serve"}, "serve": {"handle"}},
+			depth:        2,
+			want:         "main(run(serve))",
+			wantExpanded: 2,
+		},
+		{
+			name:         "recursion is marked as visited",
+			graph:        map[string][]string{"main": {"walk"}, "walk": {"walk", "visit"}},
+			depth:        5,
+			want:         "main(walk(walk* visit))",
+			wantExpanded: 3,
+		},
+		{
+			name:         "shared callee is expanded once",
+			graph:        map[string][]string{"main": {"a", "b"}, "a": {"log"}, "b": {"log"}, "log": {"write"}},
+			depth:        3,
+			want:         "main(a(log(write)) b(log*))",
+			wantExpanded: 4,
+		},
+		{
+			name:         "failed expansion leaves the function without calls",
+			graph:        map[string][]string{"main": {"broken", "ok"}, "ok": {"done"}},
+			depth:        2,
+			want:         "main(broken ok(done))",
+			wantExpanded: 3,
+		},
+		{
+			name:         "repeated callees fit the budget",
+			graph:        wide,
+			depth:        5,
+			wantExpanded: 21,
+		},
+		{
+			name:          "budget cuts wide trees short",
+			graph:         huge,
+			depth:         3,
+			wantExpanded:  maxCallNodes,
+			wantTruncated: true,
+		},
+		{
+			name:      "cancelled request",
+			graph:     map[string][]string{"main": {"run"}},
+			depth:     1,
+			cancelled: true,
+			wantErr:   true,
+		},
+	}
+
+	gt := NewGoplsTool(zap.NewNop())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+
+			root := newCallNode(item("main"), nil)
+			expanded, truncated, err := gt.expandCalls(ctx, item("main"), root, tt.depth, fetchFrom(tt.graph))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandCalls() error = %v, want error %t", err, tt.wantErr)
+			}
+			if expanded != tt.wantExpanded || truncated != tt.wantTruncated {
+				t.Errorf("expandCalls() expanded %d functions, truncated %t, want %d, %t", expanded, truncated, tt.wantExpanded, tt.wantTruncated)
+			}
+			if tt.want != "" {
+				if got := callTree(root); got != tt.want {
+					t.Errorf("tree = %s, want %s", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// callTree renders a call tree compactly, with visited functions marked by '*'
+func callTree(node *CallNode) string {
+	tree := node.Name
+	if node.Visited {
+		tree += "*"
+	}
+	if len(node.Calls) > 0 {
+		var calls []string
+		for _, call := range node.Calls {
+			calls = append(calls, callTree(call))
+		}
+		tree += "(" + strings.Join(calls, " ") + ")"
+	}
+	return tree
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// HoverInfo is the hover markdown of gopls split into its parts
+type HoverInfo struct {
+	Signature   string   `json:"signature,omitempty"`    // declaration of the symbol, e.g. func (c *Client) Do(req *Request) (*Response, error)
+	Doc         string   `json:"doc,omitempty"`          // doc comment as rendered by gopls
+	Details     []string `json:"details,omitempty"`      // further code blocks, such as the method set of a type
+	PackagePath string   `json:"package_path,omitempty"` // import path of the declaring package
+	Link        string   `json:"link,omitempty"`         // pkg.go.dev documentation link
+}
+
+var (
+	hoverCodeBlock = regexp.MustCompile("(?s)```[a-zA-Z]*\\n(.*?)\\n?```")
+	hoverDocLink   = regexp.MustCompile(`\[[^\]]*\]\((https://pkg\.go\.dev/[^)\s]+)\)`)
+)
+
+// parseHoverMarkdown splits gopls hover markdown into the signature code block, the doc
+// comment, any further code blocks and the package path of the pkg.go.dev link.
+// Sections are separated by "---" lines.
+func parseHoverMarkdown(markdown string) HoverInfo {
+	var info HoverInfo
+
+	if link := hoverDocLink.FindStringSubmatch(markdown); link != nil {
+		info.Link = link[1]
+		info.PackagePath = packagePathFromDocLink(link[1])
+		markdown = hoverDocLink.ReplaceAllString(markdown, "")
+	}
+
+	var doc []string
+	for _, section := range strings.Split(markdown, "\n---\n") {
+		for _, block := range hoverCodeBlock.FindAllStringSubmatch(section, -1) {
+			code := strings.TrimSpace(block[1])
+			if code == "" {
+				continue
+			}
+			if info.Signature == "" {
+				info.Signature = code
+			} else {
+				info.Details = append(info.Details, code)
+			}
+		}
+
+		if text := strings.TrimSpace(hoverCodeBlock.ReplaceAllString(section, "")); text != "" {
+			doc = append(doc, text)
+		}
+	}
+	info.Doc = strings.Join(doc, "\n\n")
+
+	return info
+}
+
+// packagePathFromDocLink returns the import path of a pkg.go.dev link such as
+// https://pkg.go.dev/net/http@go1.22#Client.Do
+func packagePathFromDocLink(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	path := strings.TrimPrefix(parsed.Path, "/")
+	if at := strings.Index(path, "@"); at >= 0 {
+		// The version ends the module part of the path: mod@v1.2.3/sub/pkg
+		rest := path[at+1:]
+		path = path[:at]
+		if slash := strings.Index(rest, "/"); slash >= 0 {
+			path += rest[slash:]
+		}
+	}
+	return path
+}
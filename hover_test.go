@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHoverMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     HoverInfo
+	}{
+		{
+			name:     "signature, doc and link",
+			markdown: "```go\nfunc (c *Client) Do(req *Request) (*Response, error)\n```\n\nDo sends an HTTP request.\n\n\n[`(http.Client).Do` on pkg.go.dev](https://pkg.go.dev/net/http#Client.Do)",
+			want: HoverInfo{
+				Signature:   "func (c *Client) Do(req *Request) (*Response, error)",
+				Doc:         "Do sends an HTTP request.",
+				PackagePath: "net/http",
+				Link:        "https://pkg.go.dev/net/http#Client.Do",
+			},
+		},
+		{
+			name:     "sections separated by rules",
+			markdown: "```go\ntype Client struct {\n\tName string\n}\n```\n\n---\n\nClient talks to the server.\n\n---\n\n```go\nfunc (c *Client) Close() error\n```",
+			want: HoverInfo{
+				Signature: "type Client struct {\n\tName string\n}",
+				Doc:       "Client talks to the server.",
+				Details:   []string{"func (c *Client) Close() error"},
+			},
+		},
+		{
+			name:     "signature only",
+			markdown: "```go\nvar count int\n```",
+			want:     HoverInfo{Signature: "var count int"},
+		},
+		{
+			name:     "plain text",
+			markdown: "package fmt",
+			want:     HoverInfo{Doc: "package fmt"},
+		},
+		{
+			name:     "empty code block is skipped",
+			markdown: "```go\n```\n\nJust text",
+			want:     HoverInfo{Doc: "Just text"},
+		},
+		{
+			name:     "empty",
+			markdown: "",
+			want:     HoverInfo{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseHoverMarkdown(tt.markdown); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHoverMarkdown() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackagePathFromDocLink(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{link: "https://pkg.go.dev/net/http#Client.Do", want: "net/http"},
+		{link: "https://pkg.go.dev/net/http@go1.22#Client.Do", want: "net/http"},
+		{link: "https://pkg.go.dev/go.uber.org/zap@v1.27.0#Logger", want: "go.uber.org/zap"},
+		{link: "https://pkg.go.dev/cloud.google.com/go/vertexai@v0.8.0/genai#Tool", want: "cloud.google.com/go/vertexai/genai"},
+		{link: "https://pkg.go.dev/fmt", want: "fmt"},
+		{link: "://invalid", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			if got := packagePathFromDocLink(tt.link); got != tt.want {
+				t.Errorf("packagePathFromDocLink(%q) = %q, want %q", tt.link, got, tt.want)
+			}
+		})
+	}
+}
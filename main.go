@@ -3,15 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"gemini-tool/protocol"
-
 	"cloud.google.com/go/vertexai/genai"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -34,11 +34,32 @@ func setupLogger() (*zap.Logger, error) {
 	return config.Build()
 }
 
+// defaultMaxIterations bounds how many model turns a single GenerateContent call may spend on tool calls
+const defaultMaxIterations = 10
+
+// defaultMaxParallelCalls bounds how many function calls from one turn are executed concurrently
+const defaultMaxParallelCalls = 4
+
+// ErrMaxIterationsExceeded is returned when the model keeps requesting tools past the iteration budget
+var ErrMaxIterationsExceeded = errors.New("tool loop exceeded max iterations")
+
+// chatSession is the part of genai.ChatSession the tool loop talks to
+type chatSession interface {
+	SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+}
+
 // GeminiClient wraps the Vertex AI client for Gemini 2.5 Pro
 type GeminiClient struct {
-	client *genai.Client
-	model  *genai.GenerativeModel
-	logger *zap.Logger
+	client           *genai.Client
+	model            *genai.GenerativeModel
+	startChat        func() chatSession
+	logger           *zap.Logger
+	registry         *ToolRegistry
+	goplsTool        *GoplsTool
+	maxIterations    int
+	maxParallelCalls int
+	formatCode       bool   // format the Go code blocks of final answers, off by default
+	sourcePath       string // file the generated refactoring applies to, if known
 }
 
 // NewGeminiClient creates a new Gemini client with service account credentials
@@ -67,8 +88,14 @@ func NewGeminiClient(ctx context.Context, projectID, location, credentialsPath s
 	model.SetTopK(40)
 	model.SetMaxOutputTokens(int32(geminiPro25MaxTokens))
 
-	// Setup tools
-	tools := setupTools(logger)
+	// Setup tools, sharing one lazily started gopls session across all calls
+	goplsTool := NewGoplsTool(logger)
+	registry, err := setupTools(logger, goplsTool)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to setup tools: %w", err)
+	}
+	tools := registry.Tools()
 	model.Tools = tools
 
 	logger.Info("Configured Gemini 2.5 Pro model",
@@ -76,136 +103,240 @@ func NewGeminiClient(ctx context.Context, projectID, location, credentialsPath s
 		zap.Float32("topP", 0.8),
 		zap.Int32("topK", 40),
 		zap.Int32("maxOutputTokens", geminiPro25MaxTokens),
-		zap.Int("toolsCount", len(tools)))
+		zap.Int("toolsCount", len(tools[0].FunctionDeclarations)))
 
 	return &GeminiClient{
-		client: client,
-		model:  model,
-		logger: logger,
+		client:           client,
+		model:            model,
+		startChat:        func() chatSession { return model.StartChat() },
+		logger:           logger,
+		registry:         registry,
+		goplsTool:        goplsTool,
+		maxIterations:    defaultMaxIterations,
+		maxParallelCalls: defaultMaxParallelCalls,
 	}, nil
 }
 
-// GenerateContent sends a prompt to Gemini and returns the response
+// GenerateContent sends a prompt to Gemini and runs the tool loop until the model returns a final text response
 func (gc *GeminiClient) GenerateContent(ctx context.Context, prompt string) (string, error) {
 	gc.logger.Debug("Sending prompt to Gemini", zap.String("prompt", prompt))
 
-	resp, err := gc.model.GenerateContent(ctx, genai.Text(prompt))
-	if err != nil {
-		gc.logger.Error("Failed to generate content", zap.Error(err))
-		return "", fmt.Errorf("failed to generate content: %w", err)
+	session := gc.startChat()
+	parts := []genai.Part{genai.Text(prompt)}
+
+	for iteration := 1; iteration <= gc.maxIterations; iteration++ {
+		resp, err := session.SendMessage(ctx, parts...)
+		if err != nil {
+			gc.logger.Error("Failed to generate content",
+				zap.Int("iteration", iteration),
+				zap.Error(err))
+			return "", fmt.Errorf("failed to generate content (iteration %d): %w", iteration, err)
+		}
+
+		// Store the full response of every turn to a file for debugging
+		err = gc.storeResponseToFile(resp, fmt.Sprintf("gemini_response_%d.txt", iteration))
+		if err != nil {
+			gc.logger.Warn("Failed to store response to file", zap.Error(err))
+		}
+
+		candidate, err := gc.firstCandidate(resp)
+		if err != nil {
+			return "", err
+		}
+
+		funcCalls := functionCalls(candidate)
+		if len(funcCalls) == 0 {
+			text, err := gc.extractText(resp, candidate)
+			if err != nil {
+				return "", err
+			}
+			if gc.formatCode {
+				text = gc.formatGeneratedCode(ctx, text, gc.sourcePath)
+			}
+			return text, nil
+		}
+
+		gc.logger.Info("Function calls detected",
+			zap.Int("iteration", iteration),
+			zap.Int("callCount", len(funcCalls)))
+
+		// Execute every requested call and send all responses back in the next turn
+		parts = gc.executeFunctionCalls(ctx, funcCalls)
 	}
 
-	// Store the full response to a file for debugging
-	err = gc.storeResponseToFile(resp, "gemini_response.txt")
-	if err != nil {
-		gc.logger.Warn("Failed to store response to file", zap.Error(err))
+	gc.logger.Error("Tool loop exhausted without a final response",
+		zap.Int("maxIterations", gc.maxIterations))
+	return "", fmt.Errorf("%w: no final response after %d iterations", ErrMaxIterationsExceeded, gc.maxIterations)
+}
+
+// SetFormatGeneratedCode enables or disables formatting the test_code and refactored_source_code
+// blocks of final answers. It is disabled by default, so answers are returned as the model wrote
+// them; imports are only organized once SetSourcePath names the file under test.
+func (gc *GeminiClient) SetFormatGeneratedCode(enabled bool) {
+	gc.formatCode = enabled
+}
+
+// SetSourcePath sets the source file the generated refactorings apply to, so that gopls can
+// organize the imports of the refactored source against its package
+func (gc *GeminiClient) SetSourcePath(path string) {
+	gc.sourcePath = path
+}
+
+// SetGoplsConfig sets how gopls is launched and the settings it runs with. It applies to the
+// next gopls session, so it should be called before the first analysis.
+func (gc *GeminiClient) SetGoplsConfig(config GoplsConfig) {
+	gc.goplsTool.SetConfig(config)
+}
+
+// SetMaxParallelCalls sets how many function calls of a single turn may run at the same time
+func (gc *GeminiClient) SetMaxParallelCalls(maxParallelCalls int) {
+	if maxParallelCalls < 1 {
+		maxParallelCalls = 1
+	}
+	gc.maxParallelCalls = maxParallelCalls
+}
+
+// SetMaxIterations sets how many model turns GenerateContent may spend on tool calls
+func (gc *GeminiClient) SetMaxIterations(maxIterations int) {
+	if maxIterations < 1 {
+		maxIterations = 1
 	}
+	gc.maxIterations = maxIterations
+}
 
+// firstCandidate returns the first candidate of a response, storing debug info when it is unusable
+func (gc *GeminiClient) firstCandidate(resp *genai.GenerateContentResponse) (*genai.Candidate, error) {
 	if len(resp.Candidates) == 0 {
 		gc.logger.Error("No response candidates returned")
-		err = gc.storeDebugInfo(resp, "no_candidates_debug.txt")
+		err := gc.storeDebugInfo(resp, "no_candidates_debug.txt")
 		if err != nil {
 			gc.logger.Warn("Failed to store debug info", zap.Error(err))
 		}
-		return "", fmt.Errorf("no response candidates returned")
+		return nil, fmt.Errorf("no response candidates returned")
 	}
 
-	// Extract text from the first candidate
 	candidate := resp.Candidates[0]
 	if candidate.Content == nil || len(candidate.Content.Parts) == 0 {
 		gc.logger.Error("No content in response")
-		err = gc.storeDebugInfo(resp, "no_content_debug.txt")
+		err := gc.storeDebugInfo(resp, "no_content_debug.txt")
 		if err != nil {
 			gc.logger.Warn("Failed to store debug info", zap.Error(err))
 		}
-		return "", fmt.Errorf("no content in response")
+		return nil, fmt.Errorf("no content in response")
 	}
 
-	// Check if the response contains function calls
+	return candidate, nil
+}
+
+// functionCalls collects the function call parts of a candidate in order
+func functionCalls(candidate *genai.Candidate) []genai.FunctionCall {
+	var calls []genai.FunctionCall
 	for _, part := range candidate.Content.Parts {
 		if funcCall, ok := part.(genai.FunctionCall); ok {
-			gc.logger.Info("Function call detected", zap.String("functionName", funcCall.Name))
+			calls = append(calls, funcCall)
+		}
+	}
+	return calls
+}
 
-			// Create tool instances
-			dirTool := &DirectoryStructureTool{logger: gc.logger}
-			goplsTool, err := NewGoplsTool(gc.logger)
-			if err != nil {
-				gc.logger.Error("Failed to create gopls tool", zap.Error(err))
-				return "", fmt.Errorf("failed to create gopls tool: %w", err)
-			}
-			defer goplsTool.Close()
+// extractText joins the text parts of a final candidate
+func (gc *GeminiClient) extractText(resp *genai.GenerateContentResponse, candidate *genai.Candidate) (string, error) {
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if textPart, ok := part.(genai.Text); ok {
+			text.WriteString(string(textPart))
+		}
+	}
 
-			// Handle the function call
-			result, err := handleFunctionCall(&funcCall, dirTool, goplsTool, gc.logger)
-			if err != nil {
-				gc.logger.Error("Failed to handle function call", zap.Error(err))
-				return "", fmt.Errorf("failed to handle function call: %w", err)
-			}
+	if text.Len() == 0 {
+		gc.logger.Error("Unexpected content type in response")
+		err := gc.storeDebugInfo(resp, "unexpected_content_debug.txt")
+		if err != nil {
+			gc.logger.Warn("Failed to store debug info", zap.Error(err))
+		}
+		return "", fmt.Errorf("unexpected content type in response")
+	}
 
-			// Send the function result back to Gemini
-			functionResponse := &genai.FunctionResponse{
-				Name:     funcCall.Name,
-				Response: map[string]any{"result": result},
-			}
+	response := text.String()
+	gc.logger.Info("Successfully generated content",
+		zap.Int("responseLength", len(response)))
+	return response, nil
+}
 
-			// Continue the conversation with the function result and a text prompt
-			var followUpPrompt string
-			switch funcCall.Name {
-			case "analyze_go_code":
-				if actionParam, ok := funcCall.Args["action"].(string); ok {
-					switch actionParam {
-					case "code_definitions":
-						followUpPrompt = "Based on the code definitions provided, please analyze the code structure and help with generating appropriate unit tests."
-					default:
-						followUpPrompt = "Please analyze the Go code data provided by the function call and provide relevant insights."
-					}
-				} else {
-					followUpPrompt = "Please analyze the Go code data provided by the function call and provide relevant insights."
+// executeFunctionCalls dispatches all function calls of a turn concurrently, bounded by maxParallelCalls,
+// and returns their responses in the order the model issued the calls
+func (gc *GeminiClient) executeFunctionCalls(ctx context.Context, funcCalls []genai.FunctionCall) []genai.Part {
+	responses := make([]genai.Part, len(funcCalls))
+	semaphore := make(chan struct{}, gc.maxParallelCalls)
+
+	var wg sync.WaitGroup
+	for i, funcCall := range funcCalls {
+		wg.Add(1)
+		go func(i int, funcCall genai.FunctionCall) {
+			defer wg.Done()
+
+			// Calls still queued for a slot when the request is cancelled are not run
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+			}
+			if err := ctx.Err(); err != nil {
+				gc.logger.Warn("Skipping function call of cancelled request",
+					zap.String("functionName", funcCall.Name),
+					zap.Error(err))
+				responses[i] = &genai.FunctionResponse{
+					Name:     funcCall.Name,
+					Response: map[string]any{"error": fmt.Sprintf("function call cancelled: %v", err)},
 				}
-			case "get_code_definitions":
-				followUpPrompt = "Based on the code definitions provided, please analyze the code structure and help with generating appropriate unit tests."
-			default:
-				followUpPrompt = "Please analyze the data provided by the function call and provide relevant insights."
+				return
 			}
 
-			resp2, err := gc.model.GenerateContent(ctx,
-				genai.Text(followUpPrompt),
-				functionResponse)
-			if err != nil {
-				gc.logger.Error("Failed to generate content after function call", zap.Error(err))
-				return "", fmt.Errorf("failed to generate content after function call: %w", err)
-			}
+			responses[i] = gc.handleFunctionCall(ctx, funcCall)
+		}(i, funcCall)
+	}
+	wg.Wait()
 
-			gc.logger.Info("Made second AI call with function response",
-				zap.String("followUpPrompt", followUpPrompt),
-				zap.String("functionName", funcCall.Name))
+	return responses
+}
 
-			if len(resp2.Candidates) > 0 && resp2.Candidates[0].Content != nil && len(resp2.Candidates[0].Content.Parts) > 0 {
-				if textPart, ok := resp2.Candidates[0].Content.Parts[0].(genai.Text); ok {
-					response := string(textPart)
-					gc.logger.Info("Successfully generated content with function call",
-						zap.Int("responseLength", len(response)))
-					return response, nil
-				}
-			}
+// handleFunctionCall dispatches a single function call through the tool registry and wraps the
+// outcome as a FunctionResponse. Tool failures are reported to the model instead of aborting
+// the loop so it can recover.
+func (gc *GeminiClient) handleFunctionCall(ctx context.Context, funcCall genai.FunctionCall) *genai.FunctionResponse {
+	gc.logger.Info("Function call detected", zap.String("functionName", funcCall.Name))
+
+	response, err := gc.registry.Dispatch(ctx, &funcCall)
+	if err != nil {
+		gc.logger.Warn("Failed to handle function call",
+			zap.String("functionName", funcCall.Name),
+			zap.Error(err))
+		return &genai.FunctionResponse{
+			Name:     funcCall.Name,
+			Response: map[string]any{"error": err.Error()},
 		}
 	}
 
-	// Handle regular text response
-	part := candidate.Content.Parts[0]
-	if textPart, ok := part.(genai.Text); ok {
-		response := string(textPart)
-		gc.logger.Info("Successfully generated content",
-			zap.Int("responseLength", len(response)))
-		return response, nil
+	return &genai.FunctionResponse{
+		Name:     funcCall.Name,
+		Response: response,
 	}
+}
 
-	gc.logger.Error("Unexpected content type in response")
-	return "", fmt.Errorf("unexpected content type in response")
+// RegisterTool makes an additional tool available to the model
+func (gc *GeminiClient) RegisterTool(tool Tool) error {
+	if err := gc.registry.Register(tool); err != nil {
+		return err
+	}
+	gc.model.Tools = gc.registry.Tools()
+	return nil
 }
 
-// Close closes the client connection
+// Close shuts down the gopls session and closes the client connection
 func (gc *GeminiClient) Close() error {
+	if err := gc.goplsTool.Close(); err != nil {
+		gc.logger.Warn("Failed to close gopls session", zap.Error(err))
+	}
 	return gc.client.Close()
 }
 
@@ -278,377 +409,6 @@ func (dst *DirectoryStructureTool) walkDirectory(path, prefix string, currentDep
 	return nil
 }
 
-// setupTools configures the tools for Gemini
-func setupTools(logger *zap.Logger) []*genai.Tool {
-	// Define a single comprehensive code analysis tool
-	codeAnalysisTool := &genai.Tool{
-		FunctionDeclarations: []*genai.FunctionDeclaration{
-			{
-				Name:        "analyze_go_code",
-				Description: "Analyze Go code projects - get code definitions for symbols using gopls",
-				Parameters: &genai.Schema{
-					Type: genai.TypeObject,
-					Properties: map[string]*genai.Schema{
-						"action": {
-							Type:        genai.TypeString,
-							Description: "Action to perform: 'code_definitions'",
-							Enum:        []string{"code_definitions"}, // Only code_definitions for now
-						},
-						"path": {
-							Type:        genai.TypeString,
-							Description: "The file path to analyze",
-						},
-						"symbols": {
-							Type: genai.TypeArray,
-							Items: &genai.Schema{
-								Type: genai.TypeString,
-							},
-							Description: "List of symbol names to look up for code definitions (function names, struct names, etc.)",
-						},
-					},
-					Required: []string{"action", "path", "symbols"},
-				},
-			},
-		},
-	}
-
-	tools := []*genai.Tool{codeAnalysisTool}
-	logger.Info("Configured tools for Gemini", zap.Int("toolCount", len(tools)))
-
-	return tools
-}
-
-// handleFunctionCall processes function calls from Gemini
-func handleFunctionCall(call *genai.FunctionCall, dirTool *DirectoryStructureTool, goplsTool *GoplsTool, logger *zap.Logger) (string, error) {
-	logger.Debug("Handling function call",
-		zap.String("functionName", call.Name))
-
-	switch call.Name {
-	case "analyze_go_code":
-		// Extract action parameter
-		actionParam, ok := call.Args["action"].(string)
-		if !ok {
-			return "", fmt.Errorf("action parameter is required and must be a string")
-		}
-
-		// Extract path parameter
-		pathParam, ok := call.Args["path"].(string)
-		if !ok {
-			return "", fmt.Errorf("path parameter is required and must be a string")
-		}
-
-		switch actionParam {
-		case "code_definitions":
-			// Extract symbols parameter
-			symbolsParam, ok := call.Args["symbols"].([]interface{})
-			if !ok {
-				return "", fmt.Errorf("symbols parameter is required and must be an array for code_definitions action")
-			}
-
-			// Convert interface{} slice to string slice
-			symbols := make([]string, len(symbolsParam))
-			for i, symbol := range symbolsParam {
-				if symbolStr, ok := symbol.(string); ok {
-					symbols[i] = symbolStr
-				} else {
-					return "", fmt.Errorf("symbol at index %d is not a string", i)
-				}
-			}
-
-			// Call the gopls tool
-			definitions, err := goplsTool.GetCodeDefinitions(pathParam, symbols)
-			if err != nil {
-				return "", fmt.Errorf("failed to get code definitions: %w", err)
-			}
-
-			logger.Info("Code definitions function executed successfully",
-				zap.String("functionName", call.Name),
-				zap.String("action", actionParam),
-				zap.String("filePath", pathParam),
-				zap.Strings("symbols", symbols))
-
-			return definitions, nil
-
-		default:
-			return "", fmt.Errorf("unknown action: %s", actionParam)
-		}
-
-	// Keep backward compatibility with old function names
-	case "get_directory_structure":
-		// Extract parameters
-		pathParam, ok := call.Args["path"].(string)
-		if !ok {
-			return "", fmt.Errorf("path parameter is required and must be a string")
-		}
-
-		maxDepth := 3 // default
-		if depthParam, exists := call.Args["max_depth"]; exists {
-			if depth, ok := depthParam.(float64); ok {
-				maxDepth = int(depth)
-			}
-		}
-
-		// Call the tool
-		structure, err := dirTool.GetDirectoryStructure(pathParam, maxDepth)
-		if err != nil {
-			return "", fmt.Errorf("failed to get directory structure: %w", err)
-		}
-
-		logger.Info("Function call executed successfully",
-			zap.String("functionName", call.Name),
-			zap.String("path", pathParam),
-			zap.Int("maxDepth", maxDepth))
-
-		return structure, nil
-
-	case "get_code_definitions":
-		// Extract parameters
-		filePathParam, ok := call.Args["file_path"].(string)
-		if !ok {
-			return "", fmt.Errorf("file_path parameter is required and must be a string")
-		}
-
-		symbolsParam, ok := call.Args["symbols"].([]interface{})
-		if !ok {
-			return "", fmt.Errorf("symbols parameter is required and must be an array")
-		}
-
-		// Convert interface{} slice to string slice
-		symbols := make([]string, len(symbolsParam))
-		for i, symbol := range symbolsParam {
-			if symbolStr, ok := symbol.(string); ok {
-				symbols[i] = symbolStr
-			} else {
-				return "", fmt.Errorf("symbol at index %d is not a string", i)
-			}
-		}
-
-		// Call the gopls tool
-		definitions, err := goplsTool.GetCodeDefinitions(filePathParam, symbols)
-		if err != nil {
-			return "", fmt.Errorf("failed to get code definitions: %w", err)
-		}
-
-		logger.Info("Function call executed successfully",
-			zap.String("functionName", call.Name),
-			zap.String("filePath", filePathParam),
-			zap.Strings("symbols", symbols))
-
-		return definitions, nil
-
-	default:
-		return "", fmt.Errorf("unknown function: %s", call.Name)
-	}
-}
-
-// GoplsTool represents the gopls integration tool
-type GoplsTool struct {
-	logger      *zap.Logger
-	goplsClient *GoplsClient
-}
-
-// NewGoplsTool creates a new gopls tool instance
-func NewGoplsTool(logger *zap.Logger) (*GoplsTool, error) {
-	client, err := NewGoplsClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create gopls client: %w", err)
-	}
-
-	return &GoplsTool{
-		logger:      logger,
-		goplsClient: client,
-	}, nil
-}
-
-// GetCodeDefinitions retrieves definitions for the requested symbols from gopls
-func (gt *GoplsTool) GetCodeDefinitions(filePath string, symbols []string) (string, error) {
-	gt.logger.Debug("Getting code definitions from gopls",
-		zap.String("filePath", filePath),
-		zap.Strings("symbols", symbols))
-
-	// Initialize gopls with the workspace
-	err := gt.initializeWorkspace(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to initialize workspace: %w", err)
-	}
-
-	var results strings.Builder
-	results.WriteString("Code Definitions:\n\n")
-
-	// Read the file content to find symbol positions
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
-	}
-
-	fileContent := string(content)
-
-	for _, symbol := range symbols {
-		gt.logger.Debug("Looking up symbol", zap.String("symbol", symbol))
-
-		// Find symbol position in the file
-		position := gt.findSymbolPosition(fileContent, symbol)
-		if position == nil {
-			results.WriteString(fmt.Sprintf("Symbol '%s': Not found in file\n", symbol))
-			continue
-		}
-
-		// Get definition from gopls
-		definition, err := gt.getDefinitionAtPosition(filePath, *position)
-		if err != nil {
-			gt.logger.Warn("Failed to get definition for symbol",
-				zap.String("symbol", symbol),
-				zap.Error(err))
-			results.WriteString(fmt.Sprintf("Symbol '%s': Error getting definition - %v\n", symbol, err))
-			continue
-		}
-
-		results.WriteString(fmt.Sprintf("Symbol '%s':\n", symbol))
-		results.WriteString(fmt.Sprintf("  Location: %s\n", definition.URI))
-		results.WriteString(fmt.Sprintf("  Line: %d, Character: %d\n",
-			definition.Range.Start.Line+1, definition.Range.Start.Character+1))
-
-		// Try to get the actual code content at the definition location
-		defContent, err := gt.getCodeAtLocation(definition)
-		if err == nil && defContent != "" {
-			results.WriteString(fmt.Sprintf("  Code:\n%s\n", defContent))
-		}
-		results.WriteString("\n")
-	}
-
-	result := results.String()
-	gt.logger.Info("Successfully retrieved code definitions",
-		zap.Int("symbolCount", len(symbols)),
-		zap.Int("resultLength", len(result)))
-
-	return result, nil
-}
-
-// initializeWorkspace initializes gopls with the workspace
-func (gt *GoplsTool) initializeWorkspace(filePath string) error {
-	// Initialize gopls if not already done
-	if !gt.goplsClient.initialized {
-		err := gt.goplsClient.Initialize()
-		if err != nil {
-			return fmt.Errorf("failed to initialize gopls: %w", err)
-		}
-	}
-
-	// Read file content for DidOpen
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read file content: %w", err)
-	}
-
-	// Convert file path to URI and open the document in gopls
-	uri := "file://" + filePath
-	err = gt.goplsClient.DidOpen(uri, "go", string(content))
-	if err != nil {
-		return fmt.Errorf("failed to open document in gopls: %w", err)
-	}
-
-	return nil
-}
-
-// findSymbolPosition finds the position of a symbol in the file content
-func (gt *GoplsTool) findSymbolPosition(content, symbol string) *protocol.Position {
-	lines := strings.Split(content, "\n")
-
-	for lineNum, line := range lines {
-		// Look for the symbol in various contexts
-		patterns := []string{
-			fmt.Sprintf("func %s(", symbol),
-			fmt.Sprintf("func (%s)", symbol),
-			fmt.Sprintf("type %s ", symbol),
-			fmt.Sprintf("var %s ", symbol),
-			fmt.Sprintf("const %s ", symbol),
-			fmt.Sprintf("%s :=", symbol),
-			fmt.Sprintf("%s =", symbol),
-		}
-
-		for _, pattern := range patterns {
-			if idx := strings.Index(line, pattern); idx != -1 {
-				return &protocol.Position{
-					Line:      lineNum,
-					Character: idx,
-				}
-			}
-		}
-
-		// Also try simple word boundary match
-		if strings.Contains(line, symbol) {
-			idx := strings.Index(line, symbol)
-			return &protocol.Position{
-				Line:      lineNum,
-				Character: idx,
-			}
-		}
-	}
-
-	return nil
-}
-
-// getDefinitionAtPosition gets the definition at a specific position using gopls
-func (gt *GoplsTool) getDefinitionAtPosition(filePath string, position protocol.Position) (*protocol.Location, error) {
-	// Convert file path to URI
-	uri := "file://" + filePath
-
-	locations, err := gt.goplsClient.GoToDefinition(uri, position.Line, position.Character)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(locations) == 0 {
-		return nil, fmt.Errorf("no definition found")
-	}
-
-	return &locations[0], nil
-}
-
-// getCodeAtLocation retrieves the actual code content at a given location
-func (gt *GoplsTool) getCodeAtLocation(location *protocol.Location) (string, error) {
-	// Extract file path from URI
-	filePath := strings.TrimPrefix(location.URI, "file://")
-
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", err
-	}
-
-	lines := strings.Split(string(content), "\n")
-	startLine := location.Range.Start.Line
-	endLine := location.Range.End.Line
-
-	if startLine >= len(lines) {
-		return "", fmt.Errorf("start line out of bounds")
-	}
-
-	if endLine >= len(lines) {
-		endLine = len(lines) - 1
-	}
-
-	// Extract the relevant lines
-	var result strings.Builder
-	for i := startLine; i <= endLine; i++ {
-		if i < len(lines) {
-			result.WriteString(lines[i])
-			if i < endLine {
-				result.WriteString("\n")
-			}
-		}
-	}
-
-	return result.String(), nil
-}
-
-// Close closes the gopls client
-func (gt *GoplsTool) Close() error {
-	if gt.goplsClient != nil {
-		return gt.goplsClient.Close()
-	}
-	return nil
-}
-
 // storeResponseToFile stores the Gemini response to a file
 func (gc *GeminiClient) storeResponseToFile(resp *genai.GenerateContentResponse, filePath string) error {
 	// Add timestamp to filename
@@ -736,6 +496,12 @@ func main() {
 	}
 	defer geminiClient.Close()
 
+	goplsConfig, err := GoplsConfigFromEnv()
+	if err != nil {
+		logger.Fatal("Invalid gopls configuration", zap.Error(err))
+	}
+	geminiClient.SetGoplsConfig(goplsConfig)
+
 	// Test the code definitions functionality
 	prompt := "Please use the analyze_go_code tool to get code definitions for the symbols 'GeminiClient', 'NewGeminiClient', and 'GenerateContent' from the file '/Users/ayush/keploy/havetodelete/gemini-tool-calls/main.go'."
 
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+	"go.uber.org/zap"
+)
+
+// fakeChat replays scripted model responses and records the parts sent on every turn
+type fakeChat struct {
+	responses []*genai.GenerateContentResponse
+	sent      [][]genai.Part
+}
+
+func (c *fakeChat) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	c.sent = append(c.sent, parts)
+	if len(c.sent) > len(c.responses) {
+		return nil, errors.New("no scripted response left")
+	}
+	return c.responses[len(c.sent)-1], nil
+}
+
+// modelResponse builds a model response holding the given parts
+func modelResponse(parts ...genai.Part) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: &genai.Content{Role: "model", Parts: parts}}},
+	}
+}
+
+// newTestGeminiClient returns a client talking to chat with the given tools registered
+func newTestGeminiClient(t *testing.T, chat *fakeChat, tools ...Tool) *GeminiClient {
+	t.Helper()
+
+	// Every response is stored to the working directory
+	t.Chdir(t.TempDir())
+
+	registry := NewToolRegistry(zap.NewNop())
+	for _, tool := range tools {
+		if err := registry.Register(tool); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &GeminiClient{
+		startChat:        func() chatSession { return chat },
+		logger:           zap.NewNop(),
+		registry:         registry,
+		maxIterations:    defaultMaxIterations,
+		maxParallelCalls: defaultMaxParallelCalls,
+	}
+}
+
+func TestGenerateContent(t *testing.T) {
+	echo := &stubTool{name: "echo", execute: func(ctx context.Context, args map[string]any) (any, error) {
+		return args["text"], nil
+	}}
+	fail := &stubTool{name: "fail", execute: func(ctx context.Context, args map[string]any) (any, error) {
+		return nil, errors.New("boom")
+	}}
+	calls := modelResponse(
+		genai.FunctionCall{Name: "echo", Args: map[string]any{"text": "hello"}},
+		genai.FunctionCall{Name: "fail"},
+		genai.FunctionCall{Name: "missing"},
+	)
+	callResponses := []genai.Part{
+		&genai.FunctionResponse{Name: "echo", Response: map[string]any{"result": "hello"}},
+		&genai.FunctionResponse{Name: "fail", Response: map[string]any{"error": "boom"}},
+		&genai.FunctionResponse{Name: "missing", Response: map[string]any{"error": "unknown function: missing"}},
+	}
+
+	tests := []struct {
+		name          string
+		responses     []*genai.GenerateContentResponse
+		maxIterations int
+		want          string
+		wantErr       bool
+		wantErrIs     error
+		wantSent      [][]genai.Part
+	}{
+		{
+			name:      "answer without tool calls",
+			responses: []*genai.GenerateContentResponse{modelResponse(genai.Text("done"))},
+			want:      "done",
+			wantSent:  [][]genai.Part{{genai.Text("prompt")}},
+		},
+		{
+			name:      "function responses are sent back in the order of the calls",
+			responses: []*genai.GenerateContentResponse{calls, modelResponse(genai.Text("all "), genai.Text("done"))},
+			want:      "all done",
+			wantSent:  [][]genai.Part{{genai.Text("prompt")}, callResponses},
+		},
+		{
+			name:          "tool loop stops after max iterations",
+			responses:     []*genai.GenerateContentResponse{calls, calls, modelResponse(genai.Text("too late"))},
+			maxIterations: 2,
+			wantErr:       true,
+			wantErrIs:     ErrMaxIterationsExceeded,
+			wantSent:      [][]genai.Part{{genai.Text("prompt")}, callResponses},
+		},
+		{
+			name:      "model error ends the loop",
+			responses: []*genai.GenerateContentResponse{calls},
+			wantErr:   true,
+			wantSent:  [][]genai.Part{{genai.Text("prompt")}, callResponses},
+		},
+		{
+			name:      "response without candidates",
+			responses: []*genai.GenerateContentResponse{{}},
+			wantErr:   true,
+			wantSent:  [][]genai.Part{{genai.Text("prompt")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := &fakeChat{responses: tt.responses}
+			gc := newTestGeminiClient(t, chat, echo, fail)
+			if tt.maxIterations > 0 {
+				gc.SetMaxIterations(tt.maxIterations)
+			}
+
+			got, err := gc.GenerateContent(context.Background(), "prompt")
+			if tt.wantErr {
+				if err == nil || (tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs)) {
+					t.Errorf("GenerateContent() = %q, %v, want error %v", got, err, tt.wantErrIs)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("GenerateContent() = %q, %v, want %q", got, err, tt.want)
+			}
+
+			if !reflect.DeepEqual(chat.sent, tt.wantSent) {
+				t.Errorf("sent %+v, want %+v", chat.sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestExecuteFunctionCalls(t *testing.T) {
+	var mu sync.Mutex
+	var running, maxRunning int
+	// Earlier calls take longer, so they finish after the calls that follow them
+	sleep := &stubTool{name: "sleep", execute: func(ctx context.Context, args map[string]any) (any, error) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		time.Sleep(time.Duration(args["ms"].(float64)) * time.Millisecond)
+		return args["id"], nil
+	}}
+
+	tests := []struct {
+		name             string
+		calls            int
+		maxParallelCalls int
+	}{
+		{name: "one at a time", calls: 3, maxParallelCalls: 1},
+		{name: "bounded", calls: 6, maxParallelCalls: 2},
+		{name: "all at once", calls: 4, maxParallelCalls: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxRunning = 0
+			gc := newTestGeminiClient(t, &fakeChat{}, sleep)
+			gc.SetMaxParallelCalls(tt.maxParallelCalls)
+
+			var calls []genai.FunctionCall
+			var want []genai.Part
+			for i := range tt.calls {
+				id := fmt.Sprintf("call-%d", i)
+				calls = append(calls, genai.FunctionCall{Name: "sleep", Args: map[string]any{"id": id, "ms": float64(10 * (tt.calls - i))}})
+				want = append(want, &genai.FunctionResponse{Name: "sleep", Response: map[string]any{"result": id}})
+			}
+
+			if got := gc.executeFunctionCalls(context.Background(), calls); !reflect.DeepEqual(got, want) {
+				t.Errorf("executeFunctionCalls() = %+v, want %+v", got, want)
+			}
+			if maxRunning != tt.maxParallelCalls {
+				t.Errorf("%d calls ran at the same time, want %d", maxRunning, tt.maxParallelCalls)
+			}
+		})
+	}
+}
+
+func TestExecuteFunctionCallsCancelled(t *testing.T) {
+	started := make(chan struct{}, 1)
+	var executed atomic.Int32
+	block := &stubTool{name: "block", execute: func(ctx context.Context, args map[string]any) (any, error) {
+		executed.Add(1)
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+
+	gc := newTestGeminiClient(t, &fakeChat{}, block)
+	gc.SetMaxParallelCalls(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan []genai.Part)
+	go func() {
+		done <- gc.executeFunctionCalls(ctx, []genai.FunctionCall{{Name: "block"}, {Name: "block"}, {Name: "block"}})
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no call started")
+	}
+	cancel()
+
+	var responses []genai.Part
+	select {
+	case responses = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("calls still waiting for a slot were not stopped")
+	}
+
+	if got := executed.Load(); got != 1 {
+		t.Errorf("%d calls ran, want only the one holding the slot", got)
+	}
+
+	cancelled := 0
+	for _, part := range responses {
+		response := part.(*genai.FunctionResponse)
+		switch response.Response["error"] {
+		case "context canceled":
+		case "function call cancelled: context canceled":
+			cancelled++
+		default:
+			t.Errorf("response = %+v, want a cancellation error", response.Response)
+		}
+	}
+	if cancelled != 2 {
+		t.Errorf("%d calls were skipped, want 2", cancelled)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+
+	"gemini-tool/protocol"
+)
+
+// OutlineSymbol is a declaration in a file outline. Lines are 1-based and inclusive.
+type OutlineSymbol struct {
+	Name      string          `json:"name"`
+	Kind      string          `json:"kind"`
+	Detail    string          `json:"detail,omitempty"` // type of a field, var or const, signature of a function
+	StartLine int             `json:"start_line"`
+	EndLine   int             `json:"end_line"`
+	Fields    []OutlineSymbol `json:"fields,omitempty"`
+	Methods   []OutlineSymbol `json:"methods,omitempty"`
+}
+
+// FileOutline lists the top level declarations of a Go file. Methods are listed under their
+// receiver type, or with the functions when the type is declared in another file.
+type FileOutline struct {
+	Path      string          `json:"path"`
+	Package   string          `json:"package"`
+	Lines     int             `json:"lines"`
+	Types     []OutlineSymbol `json:"types,omitempty"`
+	Functions []OutlineSymbol `json:"functions,omitempty"`
+	Constants []OutlineSymbol `json:"constants,omitempty"`
+	Variables []OutlineSymbol `json:"variables,omitempty"`
+}
+
+// buildOutline groups the hierarchical document symbols of gopls into a file outline
+func buildOutline(outline *FileOutline, symbols []protocol.DocumentSymbol) {
+	typeIndex := make(map[string]int)
+	var methods []protocol.DocumentSymbol
+
+	for _, symbol := range symbols {
+		switch symbol.Kind {
+		case protocol.SymbolKindStruct, protocol.SymbolKindInterface, protocol.SymbolKindClass:
+			typeIndex[symbol.Name] = len(outline.Types)
+			outline.Types = append(outline.Types, outlineType(symbol))
+		case protocol.SymbolKindMethod:
+			methods = append(methods, symbol)
+		case protocol.SymbolKindFunction:
+			outline.Functions = append(outline.Functions, outlineSymbol(symbol))
+		case protocol.SymbolKindConstant:
+			outline.Constants = append(outline.Constants, outlineSymbol(symbol))
+		case protocol.SymbolKindVariable:
+			outline.Variables = append(outline.Variables, outlineSymbol(symbol))
+		}
+	}
+
+	// Methods are listed after all types so receivers declared later in the file are found
+	for _, method := range methods {
+		receiver, name := splitMethodName(method.Name)
+		i, ok := typeIndex[receiver]
+		if !ok {
+			outline.Functions = append(outline.Functions, outlineSymbol(method))
+			continue
+		}
+
+		symbol := outlineSymbol(method)
+		symbol.Name = name
+		outline.Types[i].Methods = append(outline.Types[i].Methods, symbol)
+	}
+}
+
+// outlineType describes a type with its fields, or the methods of an interface
+func outlineType(symbol protocol.DocumentSymbol) OutlineSymbol {
+	outline := outlineSymbol(symbol)
+	if len(symbol.Children) > 0 {
+		// The detail of a struct or interface repeats the body already listed as children
+		outline.Detail = ""
+	}
+
+	for _, child := range symbol.Children {
+		if child.Kind == protocol.SymbolKindMethod {
+			outline.Methods = append(outline.Methods, outlineSymbol(child))
+		} else {
+			outline.Fields = append(outline.Fields, outlineSymbol(child))
+		}
+	}
+	return outline
+}
+
+func outlineSymbol(symbol protocol.DocumentSymbol) OutlineSymbol {
+	return OutlineSymbol{
+		Name:      symbol.Name,
+		Kind:      searchKindName(symbol.Kind),
+		Detail:    symbol.Detail,
+		StartLine: symbol.Range.Start.Line + 1,
+		EndLine:   symbol.Range.End.Line + 1,
+	}
+}
+
+// splitMethodName splits a gopls method symbol name such as (*Client).Do or (Set[T]).Add
+// into the receiver type name and the method name
+func splitMethodName(name string) (string, string) {
+	receiver, method, ok := strings.Cut(name, ").")
+	if !ok {
+		receiver, method, ok = strings.Cut(name, ".")
+		if !ok {
+			return "", name
+		}
+	}
+
+	receiver = strings.TrimLeft(receiver, "(*")
+	if i := strings.Index(receiver, "["); i >= 0 {
+		receiver = receiver[:i]
+	}
+	return receiver, method
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gemini-tool/protocol"
+)
+
+// documentSymbol builds a gopls document symbol spanning 0-based lines start to end
+func documentSymbol(name string, kind protocol.SymbolKind, detail string, start, end int, children ...protocol.DocumentSymbol) protocol.DocumentSymbol {
+	return protocol.DocumentSymbol{
+		Name:   name,
+		Kind:   kind,
+		Detail: detail,
+		Range: protocol.Range{
+			Start: protocol.Position{Line: start},
+			End:   protocol.Position{Line: end},
+		},
+		Children: children,
+	}
+}
+
+func TestBuildOutline(t *testing.T) {
+	tests := []struct {
+		name    string
+		symbols []protocol.DocumentSymbol
+		want    FileOutline
+	}{
+		{
+			name: "struct with fields and methods declared before and after it",
+			symbols: []protocol.DocumentSymbol{
+				documentSymbol("(*Client).Reset", protocol.SymbolKindMethod, "func()", 2, 4),
+				documentSymbol("Client", protocol.SymbolKindStruct, "struct{...}", 6, 9,
+					documentSymbol("Name", protocol.SymbolKindField, "string", 7, 7),
+				),
+				documentSymbol("(Client).String", protocol.SymbolKindMethod, "func() string", 11, 13),
+			},
+			want: FileOutline{
+				Types: []OutlineSymbol{{
+					Name: "Client", Kind: "struct", StartLine: 7, EndLine: 10,
+					Fields: []OutlineSymbol{{Name: "Name", Kind: "field", Detail: "string", StartLine: 8, EndLine: 8}},
+					Methods: []OutlineSymbol{
+						{Name: "Reset", Kind: "method", Detail: "func()", StartLine: 3, EndLine: 5},
+						{Name: "String", Kind: "method", Detail: "func() string", StartLine: 12, EndLine: 14},
+					},
+				}},
+			},
+		},
+		{
+			name: "interface methods are listed as methods",
+			symbols: []protocol.DocumentSymbol{
+				documentSymbol("Closer", protocol.SymbolKindInterface, "interface{...}", 0, 2,
+					documentSymbol("Close", protocol.SymbolKindMethod, "func() error", 1, 1),
+				),
+			},
+			want: FileOutline{
+				Types: []OutlineSymbol{{
+					Name: "Closer", Kind: "interface", StartLine: 1, EndLine: 3,
+					Methods: []OutlineSymbol{{Name: "Close", Kind: "method", Detail: "func() error", StartLine: 2, EndLine: 2}},
+				}},
+			},
+		},
+		{
+			name: "method of a type declared in another file",
+			symbols: []protocol.DocumentSymbol{
+				documentSymbol("(*Server).Start", protocol.SymbolKindMethod, "func() error", 0, 3),
+			},
+			want: FileOutline{
+				Functions: []OutlineSymbol{{Name: "(*Server).Start", Kind: "method", Detail: "func() error", StartLine: 1, EndLine: 4}},
+			},
+		},
+		{
+			name: "functions, constants and variables",
+			symbols: []protocol.DocumentSymbol{
+				documentSymbol("ID", protocol.SymbolKindClass, "int", 0, 0),
+				documentSymbol("run", protocol.SymbolKindFunction, "func()", 2, 4),
+				documentSymbol("MaxClients", protocol.SymbolKindConstant, "untyped int", 6, 6),
+				documentSymbol("ready", protocol.SymbolKindVariable, "bool", 8, 8),
+				documentSymbol("T", protocol.SymbolKindTypeParameter, "any", 10, 10), // not listed
+			},
+			want: FileOutline{
+				Types:     []OutlineSymbol{{Name: "ID", Kind: "type", Detail: "int", StartLine: 1, EndLine: 1}},
+				Functions: []OutlineSymbol{{Name: "run", Kind: "func", Detail: "func()", StartLine: 3, EndLine: 5}},
+				Constants: []OutlineSymbol{{Name: "MaxClients", Kind: "const", Detail: "untyped int", StartLine: 7, EndLine: 7}},
+				Variables: []OutlineSymbol{{Name: "ready", Kind: "var", Detail: "bool", StartLine: 9, EndLine: 9}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got FileOutline
+			buildOutline(&got, tt.symbols)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildOutline() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMethodName(t *testing.T) {
+	tests := []struct {
+		name         string
+		wantReceiver string
+		wantMethod   string
+	}{
+		{name: "(*Client).Do", wantReceiver: "Client", wantMethod: "Do"},
+		{name: "(Client).String", wantReceiver: "Client", wantMethod: "String"},
+		{name: "(Set[T]).Add", wantReceiver: "Set", wantMethod: "Add"},
+		{name: "(*Map[K, V]).Get", wantReceiver: "Map", wantMethod: "Get"},
+		{name: "Client.Do", wantReceiver: "Client", wantMethod: "Do"},
+		{name: "Do", wantReceiver: "", wantMethod: "Do"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver, method := splitMethodName(tt.name)
+			if receiver != tt.wantReceiver || method != tt.wantMethod {
+				t.Errorf("splitMethodName(%q) = %q, %q, want %q, %q", tt.name, receiver, method, tt.wantReceiver, tt.wantMethod)
+			}
+		})
+	}
+}
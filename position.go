@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"gemini-tool/protocol"
+)
+
+// offsetToPosition converts a byte offset in content to an LSP position. LSP characters
+// count UTF-16 code units, which only equals the byte column for ASCII lines.
+func offsetToPosition(content []byte, offset int) protocol.Position {
+	if offset > len(content) {
+		offset = len(content)
+	}
+
+	line := 0
+	lineStart := 0
+	for i := 0; i < offset; i++ {
+		if content[i] == '\n' {
+			line++
+			lineStart = i + 1
+		}
+	}
+
+	return protocol.Position{
+		Line:      line,
+		Character: utf16Length(content[lineStart:offset]),
+	}
+}
+
+// positionToOffset converts an LSP position to a byte offset in content. Positions past the
+// end of a line or of the content are clamped.
+func positionToOffset(content []byte, position protocol.Position) int {
+	offset := 0
+	for line := 0; line < position.Line; line++ {
+		next := indexByteFrom(content, '\n', offset)
+		if next < 0 {
+			return len(content)
+		}
+		offset = next + 1
+	}
+
+	units := 0
+	for offset < len(content) && content[offset] != '\n' && units < position.Character {
+		r, size := utf8.DecodeRune(content[offset:])
+		units += utf16.RuneLen(r)
+		if units > position.Character {
+			break
+		}
+		offset += size
+	}
+
+	return offset
+}
+
+// utf16Length returns the number of UTF-16 code units needed to encode text
+func utf16Length(text []byte) int {
+	units := 0
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		if n := utf16.RuneLen(r); n > 0 {
+			units += n
+		} else {
+			units++ // invalid UTF-8 is sent as U+FFFD
+		}
+		text = text[size:]
+	}
+	return units
+}
+
+func indexByteFrom(content []byte, b byte, from int) int {
+	for i := from; i < len(content); i++ {
+		if content[i] == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseFilePosition parses a "file:line:column" or "line:column" location with a 1-based
+// line and byte column. defaultPath is used when the file is omitted.
+func parseFilePosition(location, defaultPath string) (string, int, int, error) {
+	rest, columnText, ok := cutLast(location, ":")
+	if !ok {
+		return "", 0, 0, fmt.Errorf("invalid position %q, expected file:line:column", location)
+	}
+
+	file, lineText, ok := cutLast(rest, ":")
+	if !ok {
+		file, lineText = "", rest
+	}
+	if file == "" {
+		file = defaultPath
+	}
+
+	line, err := strconv.Atoi(lineText)
+	if err != nil || line < 1 {
+		return "", 0, 0, fmt.Errorf("invalid line in position %q", location)
+	}
+	column, err := strconv.Atoi(columnText)
+	if err != nil || column < 1 {
+		return "", 0, 0, fmt.Errorf("invalid column in position %q", location)
+	}
+
+	return file, line, column, nil
+}
+
+// lineColumnToPosition converts a 1-based line and byte column to an LSP position
+func lineColumnToPosition(content []byte, line, column int) protocol.Position {
+	lineStart := positionToOffset(content, protocol.Position{Line: line - 1})
+	lineEnd := indexByteFrom(content, '\n', lineStart)
+	if lineEnd < 0 {
+		lineEnd = len(content)
+	}
+	return offsetToPosition(content, min(lineStart+column-1, lineEnd))
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
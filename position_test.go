@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+
+	"gemini-tool/protocol"
+)
+
+// positionTestContent mixes ASCII, a 2-byte rune (é, 1 UTF-16 unit) and a 4-byte rune (😀, 2 UTF-16 units)
+const positionTestContent = "package main\nvar s = \"é😀x\"\n\nend"
+
+func TestOffsetToPosition(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+		want   protocol.Position
+	}{
+		{name: "start", offset: 0, want: protocol.Position{Line: 0, Character: 0}},
+		{name: "end of first line", offset: 12, want: protocol.Position{Line: 0, Character: 12}},
+		{name: "start of second line", offset: 13, want: protocol.Position{Line: 1, Character: 0}},
+		{name: "after 2-byte rune", offset: 24, want: protocol.Position{Line: 1, Character: 10}},
+		{name: "after 4-byte rune", offset: 28, want: protocol.Position{Line: 1, Character: 12}},
+		{name: "empty line", offset: 31, want: protocol.Position{Line: 2, Character: 0}},
+		{name: "end of content", offset: 35, want: protocol.Position{Line: 3, Character: 3}},
+		{name: "past the end is clamped", offset: 100, want: protocol.Position{Line: 3, Character: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := offsetToPosition([]byte(positionTestContent), tt.offset); got != tt.want {
+				t.Errorf("offsetToPosition(%d) = %+v, want %+v", tt.offset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPositionToOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		position protocol.Position
+		want     int
+	}{
+		{name: "start", position: protocol.Position{Line: 0, Character: 0}, want: 0},
+		{name: "start of second line", position: protocol.Position{Line: 1, Character: 0}, want: 13},
+		{name: "after 2-byte rune", position: protocol.Position{Line: 1, Character: 10}, want: 24},
+		{name: "after 4-byte rune", position: protocol.Position{Line: 1, Character: 12}, want: 28},
+		{name: "inside a surrogate pair stays before the rune", position: protocol.Position{Line: 1, Character: 11}, want: 24},
+		{name: "past the end of a line is clamped", position: protocol.Position{Line: 0, Character: 50}, want: 12},
+		{name: "past the last line is clamped", position: protocol.Position{Line: 10, Character: 0}, want: 35},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := positionToOffset([]byte(positionTestContent), tt.position); got != tt.want {
+				t.Errorf("positionToOffset(%+v) = %d, want %d", tt.position, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUTF16Length(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{text: "", want: 0},
+		{text: "abc", want: 3},
+		{text: "é", want: 1},
+		{text: "世界", want: 2},
+		{text: "😀", want: 2},
+		{text: "a😀b", want: 4},
+		{text: "\xff", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := utf16Length([]byte(tt.text)); got != tt.want {
+				t.Errorf("utf16Length(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilePosition(t *testing.T) {
+	tests := []struct {
+		location    string
+		defaultPath string
+		wantFile    string
+		wantLine    int
+		wantColumn  int
+		wantErr     bool
+	}{
+		{location: "main.go:12:5", wantFile: "main.go", wantLine: 12, wantColumn: 5},
+		{location: "/src/pkg/main.go:1:1", defaultPath: "other.go", wantFile: "/src/pkg/main.go", wantLine: 1, wantColumn: 1},
+		{location: `C:\src\main.go:3:7`, wantFile: `C:\src\main.go`, wantLine: 3, wantColumn: 7},
+		{location: "12:5", defaultPath: "main.go", wantFile: "main.go", wantLine: 12, wantColumn: 5},
+		{location: ":12:5", defaultPath: "main.go", wantFile: "main.go", wantLine: 12, wantColumn: 5},
+		{location: "12:5", wantFile: "", wantLine: 12, wantColumn: 5},
+		{location: "main.go", wantErr: true},
+		{location: "main.go:12", wantErr: true},
+		{location: "main.go:0:5", wantErr: true},
+		{location: "main.go:3:x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			file, line, column, err := parseFilePosition(tt.location, tt.defaultPath)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseFilePosition(%q) = %s:%d:%d, want an error", tt.location, file, line, column)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFilePosition(%q) failed: %v", tt.location, err)
+			}
+			if file != tt.wantFile || line != tt.wantLine || column != tt.wantColumn {
+				t.Errorf("parseFilePosition(%q) = %s:%d:%d, want %s:%d:%d", tt.location,
+					file, line, column, tt.wantFile, tt.wantLine, tt.wantColumn)
+			}
+		})
+	}
+}
+
+func TestLineColumnToPosition(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   int
+		column int // 1-based byte column
+		want   protocol.Position
+	}{
+		{name: "start", line: 1, column: 1, want: protocol.Position{Line: 0, Character: 0}},
+		{name: "ASCII column", line: 1, column: 9, want: protocol.Position{Line: 0, Character: 8}},
+		{name: "byte column after 2-byte rune", line: 2, column: 12, want: protocol.Position{Line: 1, Character: 10}},
+		{name: "byte column after 4-byte rune", line: 2, column: 16, want: protocol.Position{Line: 1, Character: 12}},
+		{name: "column past the end of the line", line: 1, column: 80, want: protocol.Position{Line: 0, Character: 12}},
+		{name: "last line without newline", line: 4, column: 4, want: protocol.Position{Line: 3, Character: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineColumnToPosition([]byte(positionTestContent), tt.line, tt.column); got != tt.want {
+				t.Errorf("lineColumnToPosition(%d, %d) = %+v, want %+v", tt.line, tt.column, got, tt.want)
+			}
+		})
+	}
+}
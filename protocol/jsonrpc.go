@@ -102,3 +102,58 @@ func (msg *JSONRPCMessage) ParseResult(target any) error {
 
 	return json.Unmarshal(msg.Result, target)
 }
+
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+func NewResponse(id any, result any) (*JSONRPCMessage, error) {
+	resultRaw, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	return &JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  resultRaw,
+	}, nil
+}
+
+func NewErrorResponse(id any, code int, message string) *JSONRPCMessage {
+	return &JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &JSONRPCError{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+func (msg *JSONRPCMessage) IsRequest() bool {
+	return msg.ID != nil && msg.Method != ""
+}
+
+func (msg *JSONRPCMessage) IsResponse() bool {
+	return msg.ID != nil && msg.Method == ""
+}
+
+func (msg *JSONRPCMessage) IsNotification() bool {
+	return msg.ID == nil && msg.Method != ""
+}
+
+func (msg *JSONRPCMessage) Kind() string {
+	switch {
+	case msg.IsRequest():
+		return "request"
+	case msg.IsResponse():
+		return "response"
+	default:
+		return "notification"
+	}
+}
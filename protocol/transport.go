@@ -10,31 +10,79 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
+// NotificationHandler receives server notifications. Handlers run on the reader goroutine
+// in the order messages arrive, so they must not block.
+type NotificationHandler func(msg *JSONRPCMessage)
+
+// RequestHandler answers a server-to-client request. The returned value is sent as the result,
+// a returned error is sent as a JSON-RPC error.
+type RequestHandler func(msg *JSONRPCMessage) (any, error)
+
 type Transport struct {
-	reader      io.Reader
-	writer      io.Writer
-	readMutex   sync.Mutex
-	writeMutex  sync.Mutex
-	headerBuf   bytes.Buffer
-	contentBuf  bytes.Buffer
-	scannerBuf  bytes.Buffer
-	contentLen  int
-	scannerInit bool
-	closed      bool
-	closeMutex  sync.Mutex
+	reader     *bufio.Reader
+	writer     io.Writer
+	closer     io.Closer // closes the underlying connection, if the transport owns one
+	writeMutex sync.Mutex
+	headerBuf  bytes.Buffer
+	contentLen int
+	closed     bool
+	closeMutex sync.Mutex
+	startOnce  sync.Once
+	done       chan struct{}
+	err        error
+	debug      atomic.Bool // log the body of every received message
+
+	pendingMutex sync.Mutex
+	pending      map[string]chan *JSONRPCMessage
+
+	handlersMutex   sync.RWMutex
+	subscribers     map[string]map[int]NotificationHandler
+	nextSubscriber  int
+	requestHandlers map[string]RequestHandler
 }
 
 func NewTransport(reader io.Reader, writer io.Writer) *Transport {
+	bufferedReader, ok := reader.(*bufio.Reader)
+	if !ok {
+		bufferedReader = bufio.NewReader(reader)
+	}
+
 	return &Transport{
-		reader: reader,
-		writer: writer,
-		closed: false,
+		reader:          bufferedReader,
+		writer:          writer,
+		closed:          false,
+		done:            make(chan struct{}),
+		pending:         make(map[string]chan *JSONRPCMessage),
+		subscribers:     make(map[string]map[int]NotificationHandler),
+		requestHandlers: make(map[string]RequestHandler),
 	}
 }
 
+// NewConnTransport creates a transport over a connection, such as a net.Conn to a gopls
+// daemon. Closing the transport closes the connection, which also stops the reader.
+func NewConnTransport(conn io.ReadWriteCloser) *Transport {
+	t := NewTransport(bufio.NewReader(conn), bufio.NewWriter(conn))
+	t.closer = conn
+	return t
+}
+
+// Start launches the background reader goroutine. Handlers should be registered before
+// calling Start so no early server message is missed. Calling Start more than once is a no-op.
+func (t *Transport) Start() {
+	t.startOnce.Do(func() {
+		go t.readLoop()
+	})
+}
+
+// SetDebug enables logging the full body of every received message. Only the kind, method or ID
+// and size of messages are logged otherwise, since responses can weigh several megabytes.
+func (t *Transport) SetDebug(enabled bool) {
+	t.debug.Store(enabled)
+}
+
 func (t *Transport) IsClosed() bool {
 	t.closeMutex.Lock()
 	defer t.closeMutex.Unlock()
@@ -42,16 +90,87 @@ func (t *Transport) IsClosed() bool {
 }
 
 func (t *Transport) Close() error {
+	t.closeWithError(fmt.Errorf("transport closed"))
+	return nil
+}
+
+// Done is closed when the transport is closed, either explicitly or because reading failed
+func (t *Transport) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the reason the transport was closed, or nil while it is open
+func (t *Transport) Err() error {
+	t.closeMutex.Lock()
+	defer t.closeMutex.Unlock()
+	return t.err
+}
+
+func (t *Transport) closeWithError(err error) {
 	t.closeMutex.Lock()
 	defer t.closeMutex.Unlock()
 
 	if t.closed {
-		return nil // Already closed
+		return // Already closed
 	}
 
 	t.closed = true
+	t.err = err
+	close(t.done)
 
-	return nil
+	if t.closer != nil {
+		if closeErr := t.closer.Close(); closeErr != nil {
+			log.Printf("⚠️ Failed to close connection: %v", closeErr)
+		}
+	}
+}
+
+// Expect registers a waiter for the response to the request with the given ID. It must be
+// called before the request is sent. The channel receives exactly one message.
+func (t *Transport) Expect(id any) <-chan *JSONRPCMessage {
+	ch := make(chan *JSONRPCMessage, 1)
+
+	t.pendingMutex.Lock()
+	t.pending[idKey(id)] = ch
+	t.pendingMutex.Unlock()
+
+	return ch
+}
+
+// Forget removes the waiter for an ID whose response is no longer wanted
+func (t *Transport) Forget(id any) {
+	t.pendingMutex.Lock()
+	delete(t.pending, idKey(id))
+	t.pendingMutex.Unlock()
+}
+
+// Subscribe registers a handler for notifications with the given method and returns
+// a function that removes it
+func (t *Transport) Subscribe(method string, handler NotificationHandler) func() {
+	t.handlersMutex.Lock()
+	defer t.handlersMutex.Unlock()
+
+	t.nextSubscriber++
+	subscriberID := t.nextSubscriber
+
+	if t.subscribers[method] == nil {
+		t.subscribers[method] = make(map[int]NotificationHandler)
+	}
+	t.subscribers[method][subscriberID] = handler
+
+	return func() {
+		t.handlersMutex.Lock()
+		defer t.handlersMutex.Unlock()
+		delete(t.subscribers[method], subscriberID)
+	}
+}
+
+// HandleRequest registers the handler answering server requests with the given method,
+// replacing any previous handler. Unhandled requests are answered with MethodNotFound.
+func (t *Transport) HandleRequest(method string, handler RequestHandler) {
+	t.handlersMutex.Lock()
+	defer t.handlersMutex.Unlock()
+	t.requestHandlers[method] = handler
 }
 
 func (t *Transport) SendMessage(msg *JSONRPCMessage) error {
@@ -68,18 +187,18 @@ func (t *Transport) SendMessage(msg *JSONRPCMessage) error {
 	}
 
 	if _, err := fmt.Fprintf(t.writer, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
-		t.Close()
+		t.closeWithError(err)
 		return fmt.Errorf("failed to write header (transport closed): %w", err)
 	}
 
 	if _, err := t.writer.Write(data); err != nil {
-		t.Close()
+		t.closeWithError(err)
 		return fmt.Errorf("failed to write content (transport closed): %w", err)
 	}
 
 	if f, ok := t.writer.(interface{ Flush() error }); ok {
 		if err := f.Flush(); err != nil {
-			t.Close()
+			t.closeWithError(err)
 			return fmt.Errorf("failed to flush writer (transport closed): %w", err)
 		}
 	}
@@ -87,101 +206,120 @@ func (t *Transport) SendMessage(msg *JSONRPCMessage) error {
 	return nil
 }
 
-func (t *Transport) ReceiveMessage() (*JSONRPCMessage, error) {
-	t.readMutex.Lock()
-	defer t.readMutex.Unlock()
+func (t *Transport) readLoop() {
+	for {
+		msg, err := t.readMessage()
+		if err != nil {
+			if t.IsClosed() {
+				return
+			}
+			log.Printf("❌ Transport reader stopped: %v", err)
+			t.closeWithError(err)
+			return
+		}
+
+		t.dispatch(msg)
+	}
+}
+
+func (t *Transport) readMessage() (*JSONRPCMessage, error) {
+	contentLength, err := t.readHeader()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
 
-	if t.IsClosed() {
-		return nil, fmt.Errorf("transport closed")
+	content, err := t.readContent(contentLength)
+	if err != nil {
+		return nil, fmt.Errorf("error reading content: %w", err)
 	}
 
-	timeoutDuration := 30 * time.Second
-	resultCh := make(chan struct {
-		msg *JSONRPCMessage
-		err error
-	}, 1)
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return nil, fmt.Errorf("error deserializing JSON-RPC message: %w", err)
+	}
 
-	go func() {
-		for {
-			contentLength, err := t.readHeader()
-			if err != nil {
-				if err == io.EOF || strings.Contains(err.Error(), "pipe") || strings.Contains(err.Error(), "connection") {
-					t.Close()
-					resultCh <- struct {
-						msg *JSONRPCMessage
-						err error
-					}{nil, fmt.Errorf("error reading header (transport closed): %w", err)}
-					return
-				}
-				resultCh <- struct {
-					msg *JSONRPCMessage
-					err error
-				}{nil, fmt.Errorf("error reading header: %w", err)}
-				return
-			}
+	if msg.IsResponse() {
+		log.Printf("📥 response received (id %v, %d bytes)", msg.ID, len(content))
+	} else {
+		log.Printf("📥 %s received: %s (%d bytes)", msg.Kind(), msg.Method, len(content))
+	}
+	if t.debug.Load() {
+		log.Printf("📥 %s body: %s", msg.Kind(), content)
+	}
 
-			content, err := t.readContent(contentLength)
-			if err != nil {
-				if err == io.EOF || strings.Contains(err.Error(), "pipe") || strings.Contains(err.Error(), "connection") {
-					t.Close()
-					resultCh <- struct {
-						msg *JSONRPCMessage
-						err error
-					}{nil, fmt.Errorf("error reading content (transport closed): %w", err)}
-					return
-				}
-				resultCh <- struct {
-					msg *JSONRPCMessage
-					err error
-				}{nil, fmt.Errorf("error reading content: %w", err)}
-				return
-			}
+	return &msg, nil
+}
 
-			var msg JSONRPCMessage
-			if err := json.Unmarshal(content, &msg); err != nil {
-				resultCh <- struct {
-					msg *JSONRPCMessage
-					err error
-				}{nil, fmt.Errorf("error deserializing JSON-RPC message: %w", err)}
-				return
-			}
+func (t *Transport) dispatch(msg *JSONRPCMessage) {
+	switch {
+	case msg.IsResponse():
+		key := idKey(msg.ID)
 
-			messageType := "response"
-			if msg.ID == nil {
-				messageType = "notification"
-			}
-			log.Printf("📥 %s message received: %s", messageType, string(content))
+		t.pendingMutex.Lock()
+		ch, ok := t.pending[key]
+		delete(t.pending, key)
+		t.pendingMutex.Unlock()
 
-			if msg.ID == nil {
-				log.Printf("⏭️ Ignoring notification: %s", msg.Method)
-				continue
-			}
+		if !ok {
+			log.Printf("⚠️ No waiter for response ID %v, ignored", msg.ID)
+			return
+		}
+		ch <- msg
+
+	case msg.IsRequest():
+		t.handlersMutex.RLock()
+		handler := t.requestHandlers[msg.Method]
+		t.handlersMutex.RUnlock()
+
+		// Answer on a separate goroutine so a slow handler does not stall the reader
+		go t.answer(msg, handler)
 
-			resultCh <- struct {
-				msg *JSONRPCMessage
-				err error
-			}{&msg, nil}
+	default:
+		t.handlersMutex.RLock()
+		handlers := make([]NotificationHandler, 0, len(t.subscribers[msg.Method]))
+		for _, handler := range t.subscribers[msg.Method] {
+			handlers = append(handlers, handler)
+		}
+		t.handlersMutex.RUnlock()
+
+		if len(handlers) == 0 {
+			log.Printf("⏭️ Ignoring notification: %s", msg.Method)
 			return
 		}
-	}()
 
-	select {
-	case result := <-resultCh:
-		return result.msg, result.err
-	case <-time.After(timeoutDuration):
-		return nil, fmt.Errorf("timeout: no response received after %v seconds", timeoutDuration.Seconds())
+		for _, handler := range handlers {
+			handler(msg)
+		}
+	}
+}
+
+func (t *Transport) answer(msg *JSONRPCMessage, handler RequestHandler) {
+	var resp *JSONRPCMessage
+	var err error
+
+	if handler == nil {
+		log.Printf("⚠️ Unhandled server request: %s", msg.Method)
+		resp = NewErrorResponse(msg.ID, MethodNotFound, fmt.Sprintf("method not supported: %s", msg.Method))
+	} else if result, handlerErr := handler(msg); handlerErr != nil {
+		resp = NewErrorResponse(msg.ID, InternalError, handlerErr.Error())
+	} else {
+		resp, err = NewResponse(msg.ID, result)
+		if err != nil {
+			resp = NewErrorResponse(msg.ID, InternalError, err.Error())
+		}
+	}
+
+	if err := t.SendMessage(resp); err != nil {
+		log.Printf("❌ Failed to answer server request %s: %v", msg.Method, err)
 	}
 }
 
 func (t *Transport) readHeader() (int, error) {
 	t.headerBuf.Reset()
-	s, ok := t.reader.(*bufio.Reader)
-	if !ok {
-		s = bufio.NewReader(t.reader)
-	}
+	t.contentLen = 0
 
 	for {
-		line, err := s.ReadString('\n')
+		line, err := t.reader.ReadString('\n')
 		if err != nil {
 			return 0, fmt.Errorf("error reading header line: %w", err)
 		}
@@ -224,3 +362,18 @@ func (t *Transport) readContent(length int) ([]byte, error) {
 
 	return content, nil
 }
+
+// idKey normalizes request IDs so that an int64 sent by the client matches the
+// json.Number decoded from the server's response
+func idKey(id any) string {
+	switch v := id.(type) {
+	case string:
+		return "s:" + v
+	case json.Number:
+		return "n:" + v.String()
+	case float64:
+		return "n:" + strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return "n:" + fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,252 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIDKey(t *testing.T) {
+	tests := []struct {
+		name string
+		id   any
+		want string
+	}{
+		{name: "int64 sent by the client", id: int64(42), want: "n:42"},
+		{name: "number decoded from a response", id: json.Number("42"), want: "n:42"},
+		{name: "float64", id: float64(42), want: "n:42"},
+		{name: "int", id: 42, want: "n:42"},
+		{name: "string", id: "42", want: "s:42"},
+		{name: "empty string", id: "", want: "s:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := idKey(tt.id); got != tt.want {
+				t.Errorf("idKey(%#v) = %q, want %q", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+// newTransportPair connects a client and a server transport with in-memory pipes
+func newTransportPair(t *testing.T) (client, server *Transport) {
+	t.Helper()
+
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+	t.Cleanup(func() {
+		clientWriter.Close()
+		serverWriter.Close()
+	})
+
+	return NewTransport(clientReader, clientWriter), NewTransport(serverReader, serverWriter)
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		var zero T
+		return zero
+	}
+}
+
+func TestTransportDispatch(t *testing.T) {
+	client, server := newTransportPair(t)
+
+	notifications := make(chan *JSONRPCMessage, 1)
+	client.Subscribe("window/logMessage", func(msg *JSONRPCMessage) {
+		notifications <- msg
+	})
+	client.HandleRequest("workspace/configuration", func(msg *JSONRPCMessage) (any, error) {
+		return []string{"ok"}, nil
+	})
+	client.HandleRequest("window/showMessageRequest", func(msg *JSONRPCMessage) (any, error) {
+		return nil, fmt.Errorf("no user to ask")
+	})
+
+	requests := make(chan *JSONRPCMessage, 1)
+	server.Subscribe("textDocument/hover", func(msg *JSONRPCMessage) {
+		requests <- msg
+	})
+
+	client.Start()
+	server.Start()
+
+	t.Run("response is routed to the waiter of its id", func(t *testing.T) {
+		responses := client.Expect(int64(7))
+
+		response, err := NewResponse(json.Number("7"), "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.SendMessage(response); err != nil {
+			t.Fatal(err)
+		}
+
+		var result string
+		if err := receive(t, responses).ParseResult(&result); err != nil || result != "hello" {
+			t.Errorf("got result %q, %v, want %q", result, err, "hello")
+		}
+	})
+
+	t.Run("notification is delivered to subscribers", func(t *testing.T) {
+		notification, err := NewNotification("window/logMessage", map[string]any{"message": "loaded"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.SendMessage(notification); err != nil {
+			t.Fatal(err)
+		}
+
+		if msg := receive(t, notifications); msg.Method != "window/logMessage" {
+			t.Errorf("got notification %q, want window/logMessage", msg.Method)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		method    string
+		wantCode  int
+		wantValue string
+	}{
+		{name: "handled request is answered", method: "workspace/configuration", wantValue: `["ok"]`},
+		{name: "handler error is sent back", method: "window/showMessageRequest", wantCode: InternalError},
+		{name: "unhandled request is rejected", method: "workspace/unknown", wantCode: MethodNotFound},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := fmt.Sprintf("server-%d", i)
+			responses := server.Expect(id)
+
+			request, err := NewRequest(id, tt.method, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := server.SendMessage(request); err != nil {
+				t.Fatal(err)
+			}
+
+			response := receive(t, responses)
+			if tt.wantCode != 0 {
+				if response.Error == nil || response.Error.Code != tt.wantCode {
+					t.Errorf("got error %v, want code %d", response.Error, tt.wantCode)
+				}
+				return
+			}
+			if response.Error != nil || string(response.Result) != tt.wantValue {
+				t.Errorf("got result %s, error %v, want %s", response.Result, response.Error, tt.wantValue)
+			}
+		})
+	}
+
+	t.Run("request without handler on the client side is not a notification", func(t *testing.T) {
+		request, err := NewRequest(int64(1), "textDocument/hover", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses := client.Expect(int64(1))
+		if err := client.SendMessage(request); err != nil {
+			t.Fatal(err)
+		}
+
+		if response := receive(t, responses); response.Error == nil || response.Error.Code != MethodNotFound {
+			t.Errorf("got error %v, want code %d", response.Error, MethodNotFound)
+		}
+		select {
+		case msg := <-requests:
+			t.Errorf("request %q was delivered to notification subscribers", msg.Method)
+		default:
+		}
+	})
+}
+
+// syncBuffer collects log output written from several goroutines, such as the read loops of
+// transports started by other tests
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.buf.Reset()
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestReadMessageLogging(t *testing.T) {
+	body := strings.Repeat("x", 1024)
+	response, err := NewResponse(json.Number("3"), map[string]string{"symbols": body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification, err := NewNotification("textDocument/publishDiagnostics", map[string]string{"uri": body})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		msg      *JSONRPCMessage
+		debug    bool
+		wantLine string
+	}{
+		{name: "response", msg: response, wantLine: "response received (id 3, "},
+		{name: "notification", msg: notification, wantLine: "notification received: textDocument/publishDiagnostics ("},
+		{name: "body is logged in debug mode", msg: response, debug: true, wantLine: "response body: "},
+	}
+
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			framed := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(data), data)
+
+			transport := NewTransport(strings.NewReader(framed), io.Discard)
+			transport.SetDebug(tt.debug)
+
+			logs.Reset()
+			if _, err := transport.readMessage(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(logs.String(), tt.wantLine) {
+				t.Errorf("log = %q, want a line containing %q", logs.String(), tt.wantLine)
+			}
+			if strings.Contains(logs.String(), body) != tt.debug {
+				t.Errorf("log = %q, body logged: %t, want %t", logs.String(), !tt.debug, tt.debug)
+			}
+		})
+	}
+}
@@ -1,5 +1,11 @@
 package protocol
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 // Position représente une position dans un document texte
 type Position struct {
 	Line      int `json:"line"`
@@ -42,11 +48,18 @@ type ReferenceParams struct {
 
 // Diagnostic représente un diagnostic comme un problème de code
 type Diagnostic struct {
-	Range    Range  `json:"range"`
-	Severity int    `json:"severity,omitempty"` // 1=Error, 2=Warning, 3=Info, 4=Hint
-	Code     string `json:"code,omitempty"`
-	Source   string `json:"source,omitempty"`
-	Message  string `json:"message"`
+	Range              Range                          `json:"range"`
+	Severity           DiagnosticSeverity             `json:"severity,omitempty"` // 1=Error, 2=Warning, 3=Info, 4=Hint
+	Code               any                            `json:"code,omitempty"`     // chaîne ou entier selon le serveur
+	Source             string                         `json:"source,omitempty"`
+	Message            string                         `json:"message"`
+	RelatedInformation []DiagnosticRelatedInformation `json:"relatedInformation,omitempty"`
+}
+
+// DiagnosticRelatedInformation relie un diagnostic à un autre emplacement du code
+type DiagnosticRelatedInformation struct {
+	Location Location `json:"location"`
+	Message  string   `json:"message"`
 }
 
 // DiagnosticSeverity énumère les niveaux de sévérité des diagnostics
@@ -58,3 +71,461 @@ const (
 	SeverityInfo    DiagnosticSeverity = 3
 	SeverityHint    DiagnosticSeverity = 4
 )
+
+// String retourne le nom lisible du niveau de sévérité
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	case SeverityHint:
+		return "hint"
+	default:
+		return "unknown"
+	}
+}
+
+// PublishDiagnosticsParams paramètres de la notification textDocument/publishDiagnostics
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Version     *int         `json:"version,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// DocumentDiagnosticParams paramètres de la requête textDocument/diagnostic
+type DocumentDiagnosticParams struct {
+	TextDocument     TextDocumentIdentifier `json:"textDocument"`
+	PreviousResultID string                 `json:"previousResultId,omitempty"`
+}
+
+// DocumentDiagnosticReport résultat d'une requête textDocument/diagnostic
+type DocumentDiagnosticReport struct {
+	Kind     string       `json:"kind"` // "full" ou "unchanged"
+	ResultID string       `json:"resultId,omitempty"`
+	Items    []Diagnostic `json:"items,omitempty"`
+}
+
+// MarkupContent représente un contenu en texte brut ou en markdown
+type MarkupContent struct {
+	Kind  string `json:"kind"` // "plaintext" ou "markdown"
+	Value string `json:"value"`
+}
+
+// UnmarshalJSON accepte aussi une simple chaîne, traitée comme du texte brut
+func (m *MarkupContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = MarkupContent{Kind: "plaintext", Value: text}
+		return nil
+	}
+
+	type markupContent MarkupContent
+	return json.Unmarshal(data, (*markupContent)(m))
+}
+
+// Hover résultat d'une requête textDocument/hover
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
+
+// UnmarshalJSON accepte aussi les anciennes formes MarkedString et MarkedString[] du champ contents
+func (h *Hover) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Contents json.RawMessage `json:"contents"`
+		Range    *Range          `json:"range,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	h.Range = raw.Range
+	h.Contents = MarkupContent{}
+
+	// Une chaîne seule est une MarkedString, donc du markdown
+	var markup MarkupContent
+	if !strings.HasPrefix(strings.TrimSpace(string(raw.Contents)), `"`) &&
+		json.Unmarshal(raw.Contents, &markup) == nil && markup.Kind != "" {
+		h.Contents = markup
+		return nil
+	}
+
+	var marked []json.RawMessage
+	if err := json.Unmarshal(raw.Contents, &marked); err != nil {
+		marked = []json.RawMessage{raw.Contents}
+	}
+
+	var parts []string
+	for _, item := range marked {
+		var text string
+		if err := json.Unmarshal(item, &text); err == nil {
+			parts = append(parts, text)
+			continue
+		}
+
+		var code struct {
+			Language string `json:"language"`
+			Value    string `json:"value"`
+		}
+		if err := json.Unmarshal(item, &code); err != nil {
+			return fmt.Errorf("contenu de hover invalide: %w", err)
+		}
+		parts = append(parts, "```"+code.Language+"\n"+code.Value+"\n```")
+	}
+
+	h.Contents = MarkupContent{Kind: "markdown", Value: strings.Join(parts, "\n\n")}
+	return nil
+}
+
+// SymbolKind énumère les genres de symboles LSP
+type SymbolKind int
+
+const (
+	SymbolKindClass         SymbolKind = 5
+	SymbolKindMethod        SymbolKind = 6
+	SymbolKindField         SymbolKind = 8
+	SymbolKindInterface     SymbolKind = 11
+	SymbolKindFunction      SymbolKind = 12
+	SymbolKindVariable      SymbolKind = 13
+	SymbolKindConstant      SymbolKind = 14
+	SymbolKindStruct        SymbolKind = 23
+	SymbolKindTypeParameter SymbolKind = 26
+)
+
+var symbolKindNames = [...]string{
+	1: "file", 2: "module", 3: "namespace", 4: "package", 5: "class", 6: "method", 7: "property",
+	8: "field", 9: "constructor", 10: "enum", 11: "interface", 12: "function", 13: "variable",
+	14: "constant", 15: "string", 16: "number", 17: "boolean", 18: "array", 19: "object",
+	20: "key", 21: "null", 22: "enum_member", 23: "struct", 24: "event", 25: "operator",
+	26: "type_parameter",
+}
+
+// String retourne le nom lisible du genre de symbole
+func (k SymbolKind) String() string {
+	if k > 0 && int(k) < len(symbolKindNames) {
+		return symbolKindNames[k]
+	}
+	return "unknown"
+}
+
+// CallHierarchyItem représente une fonction ou une méthode dans une hiérarchie d'appels
+type CallHierarchyItem struct {
+	Name           string     `json:"name"`
+	Kind           SymbolKind `json:"kind"`
+	Detail         string     `json:"detail,omitempty"`
+	URI            string     `json:"uri"`
+	Range          Range      `json:"range"`
+	SelectionRange Range      `json:"selectionRange"`
+	Data           any        `json:"data,omitempty"`
+}
+
+// CallHierarchyCallsParams paramètres des requêtes callHierarchy/incomingCalls et callHierarchy/outgoingCalls
+type CallHierarchyCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+}
+
+// CallHierarchyIncomingCall appel entrant: From appelle l'élément aux emplacements FromRanges
+type CallHierarchyIncomingCall struct {
+	From       CallHierarchyItem `json:"from"`
+	FromRanges []Range           `json:"fromRanges"`
+}
+
+// CallHierarchyOutgoingCall appel sortant: l'élément appelle To aux emplacements FromRanges
+type CallHierarchyOutgoingCall struct {
+	To         CallHierarchyItem `json:"to"`
+	FromRanges []Range           `json:"fromRanges"`
+}
+
+// WorkspaceSymbolParams paramètres de la requête workspace/symbol
+type WorkspaceSymbolParams struct {
+	Query string `json:"query"`
+}
+
+// SymbolInformation décrit un symbole trouvé par workspace/symbol
+type SymbolInformation struct {
+	Name          string     `json:"name"`
+	Kind          SymbolKind `json:"kind"`
+	Location      Location   `json:"location"`
+	ContainerName string     `json:"containerName,omitempty"`
+}
+
+// DocumentSymbolParams paramètres de la requête textDocument/documentSymbol
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DocumentSymbol symbole hiérarchique d'un document, avec ses enfants (champs, méthodes d'interface...)
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           SymbolKind       `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// VersionedTextDocumentIdentifier identifie une version précise d'un document texte
+type VersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version *int   `json:"version"`
+}
+
+// TextEdit remplace une plage d'un document par un nouveau texte
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// DocumentChange est soit une modification de document (TextDocument et Edits), soit une
+// opération sur un fichier indiquée par Kind: "create", "rename" ou "delete"
+type DocumentChange struct {
+	Kind         string                           `json:"kind,omitempty"`
+	TextDocument *VersionedTextDocumentIdentifier `json:"textDocument,omitempty"`
+	Edits        []TextEdit                       `json:"edits,omitempty"`
+	URI          string                           `json:"uri,omitempty"`
+	OldURI       string                           `json:"oldUri,omitempty"`
+	NewURI       string                           `json:"newUri,omitempty"`
+}
+
+// WorkspaceEdit ensemble de modifications portant sur plusieurs documents
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []DocumentChange      `json:"documentChanges,omitempty"`
+}
+
+// RenameParams paramètres de la requête textDocument/rename
+type RenameParams struct {
+	TextDocumentPositionParams
+	NewName string `json:"newName"`
+}
+
+// CodeActionContext contexte d'une requête textDocument/codeAction
+type CodeActionContext struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Only        []string     `json:"only,omitempty"`
+}
+
+// CodeActionParams paramètres de la requête textDocument/codeAction
+type CodeActionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Context      CodeActionContext      `json:"context"`
+}
+
+// Command référence une commande exécutable par le serveur via workspace/executeCommand
+type Command struct {
+	Title     string `json:"title"`
+	Command   string `json:"command"`
+	Arguments []any  `json:"arguments,omitempty"`
+}
+
+// CodeAction action proposée par le serveur: une modification, une commande, ou les deux
+type CodeAction struct {
+	Title       string         `json:"title"`
+	Kind        string         `json:"kind,omitempty"`
+	Diagnostics []Diagnostic   `json:"diagnostics,omitempty"`
+	IsPreferred bool           `json:"isPreferred,omitempty"`
+	Edit        *WorkspaceEdit `json:"edit,omitempty"`
+	Command     *Command       `json:"command,omitempty"`
+}
+
+// UnmarshalJSON accepte aussi une simple Command, que textDocument/codeAction peut renvoyer à la place d'une CodeAction
+func (a *CodeAction) UnmarshalJSON(data []byte) error {
+	var probe struct {
+		Command json.RawMessage `json:"command"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+
+	if len(probe.Command) > 0 && probe.Command[0] == '"' {
+		var command Command
+		if err := json.Unmarshal(data, &command); err != nil {
+			return err
+		}
+		*a = CodeAction{Title: command.Title, Command: &command}
+		return nil
+	}
+
+	type codeAction CodeAction
+	return json.Unmarshal(data, (*codeAction)(a))
+}
+
+// ExecuteCommandParams paramètres de la requête workspace/executeCommand
+type ExecuteCommandParams struct {
+	Command   string `json:"command"`
+	Arguments []any  `json:"arguments,omitempty"`
+}
+
+// ApplyWorkspaceEditParams paramètres de la requête serveur workspace/applyEdit
+type ApplyWorkspaceEditParams struct {
+	Label string        `json:"label,omitempty"`
+	Edit  WorkspaceEdit `json:"edit"`
+}
+
+// ApplyWorkspaceEditResult réponse du client à workspace/applyEdit
+type ApplyWorkspaceEditResult struct {
+	Applied       bool   `json:"applied"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// FileChangeType énumère les types de changement de fichier de workspace/didChangeWatchedFiles
+type FileChangeType int
+
+const (
+	FileCreated FileChangeType = 1
+	FileChanged FileChangeType = 2
+	FileDeleted FileChangeType = 3
+)
+
+// FileEvent décrit un fichier modifié sur le disque
+type FileEvent struct {
+	URI  string         `json:"uri"`
+	Type FileChangeType `json:"type"`
+}
+
+// DidChangeWatchedFilesParams paramètres de la notification workspace/didChangeWatchedFiles
+type DidChangeWatchedFilesParams struct {
+	Changes []FileEvent `json:"changes"`
+}
+
+// FormattingOptions options de mise en forme d'un document
+type FormattingOptions struct {
+	TabSize      int  `json:"tabSize"`
+	InsertSpaces bool `json:"insertSpaces"`
+}
+
+// DocumentFormattingParams paramètres de la requête textDocument/formatting
+type DocumentFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Options      FormattingOptions      `json:"options"`
+}
+
+// CompletionItemKind énumère les genres d'éléments de complétion
+type CompletionItemKind int
+
+var completionItemKindNames = [...]string{
+	1: "text", 2: "method", 3: "function", 4: "constructor", 5: "field", 6: "variable",
+	7: "class", 8: "interface", 9: "module", 10: "property", 11: "unit", 12: "value",
+	13: "enum", 14: "keyword", 15: "snippet", 16: "color", 17: "file", 18: "reference",
+	19: "folder", 20: "enum_member", 21: "constant", 22: "struct", 23: "event",
+	24: "operator", 25: "type_parameter",
+}
+
+// String retourne le nom lisible du genre d'élément de complétion
+func (k CompletionItemKind) String() string {
+	if k > 0 && int(k) < len(completionItemKindNames) {
+		return completionItemKindNames[k]
+	}
+	return "unknown"
+}
+
+// CompletionItem élément proposé par une requête textDocument/completion
+type CompletionItem struct {
+	Label         string             `json:"label"`
+	Kind          CompletionItemKind `json:"kind,omitempty"`
+	Detail        string             `json:"detail,omitempty"`
+	Documentation *MarkupContent     `json:"documentation,omitempty"`
+	Deprecated    bool               `json:"deprecated,omitempty"`
+	SortText      string             `json:"sortText,omitempty"`
+	FilterText    string             `json:"filterText,omitempty"`
+	InsertText    string             `json:"insertText,omitempty"`
+	TextEdit      *TextEdit          `json:"textEdit,omitempty"`
+}
+
+// CompletionList résultat d'une requête textDocument/completion
+type CompletionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []CompletionItem `json:"items"`
+}
+
+// ParameterInformation paramètre d'une signature. Label est soit une chaîne, soit un
+// intervalle [début, fin) en unités UTF-16 dans le libellé de la signature.
+type ParameterInformation struct {
+	Label         json.RawMessage `json:"label"`
+	Documentation *MarkupContent  `json:"documentation,omitempty"`
+}
+
+// SignatureInformation signature d'une fonction appelable
+type SignatureInformation struct {
+	Label           string                 `json:"label"`
+	Documentation   *MarkupContent         `json:"documentation,omitempty"`
+	Parameters      []ParameterInformation `json:"parameters,omitempty"`
+	ActiveParameter *int                   `json:"activeParameter,omitempty"`
+}
+
+// SignatureHelp résultat d'une requête textDocument/signatureHelp
+type SignatureHelp struct {
+	Signatures      []SignatureInformation `json:"signatures"`
+	ActiveSignature int                    `json:"activeSignature,omitempty"`
+	ActiveParameter int                    `json:"activeParameter,omitempty"`
+}
+
+// TextDocumentSyncKind indique comment le serveur veut recevoir les modifications de documents
+type TextDocumentSyncKind int
+
+const (
+	SyncNone        TextDocumentSyncKind = 0
+	SyncFull        TextDocumentSyncKind = 1
+	SyncIncremental TextDocumentSyncKind = 2
+)
+
+// TextDocumentItem document texte transmis à l'ouverture
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+// DidOpenTextDocumentParams paramètres de la notification textDocument/didOpen
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+// TextDocumentContentChangeEvent modification d'un document: le remplacement d'une plage,
+// ou du document entier lorsque Range est absent
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+// DidChangeTextDocumentParams paramètres de la notification textDocument/didChange
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+// DidCloseTextDocumentParams paramètres de la notification textDocument/didClose
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// WorkDoneProgressCreateParams paramètres de la requête window/workDoneProgress/create.
+// Le jeton est un entier ou une chaîne.
+type WorkDoneProgressCreateParams struct {
+	Token json.RawMessage `json:"token"`
+}
+
+// ProgressParams paramètres de la notification $/progress
+type ProgressParams struct {
+	Token json.RawMessage `json:"token"`
+	Value json.RawMessage `json:"value"`
+}
+
+// WorkDoneProgressValue valeur d'une notification $/progress de travail en cours.
+// Kind vaut "begin", "report" ou "end".
+type WorkDoneProgressValue struct {
+	Kind       string `json:"kind"`
+	Title      string `json:"title,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Percentage *int   `json:"percentage,omitempty"`
+}
+
+// CancelParams paramètres de la notification $/cancelRequest
+type CancelParams struct {
+	ID any `json:"id"`
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/vertexai/genai"
+	"go.uber.org/zap"
+)
+
+// renameSymbolArgs are the arguments of rename_symbol
+type renameSymbolArgs struct {
+	Path     string `json:"path"`
+	Symbol   string `json:"symbol"`
+	Position string `json:"position"`
+	NewName  string `json:"new_name"`
+	DryRun   bool   `json:"dry_run"`
+}
+
+// newRenameSymbolTool exposes gopls rename as rename_symbol
+func newRenameSymbolTool(logger *zap.Logger, goplsTool *GoplsTool) Tool {
+	declaration := &genai.FunctionDeclaration{
+		Name:        "rename_symbol",
+		Description: "Rename a Go identifier and every reference to it across the workspace using gopls. Files are written atomically; with dry_run the unified diff is returned without touching the files. Actions running a command other than a gopls fix cannot be previewed",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"path": {
+					Type:        genai.TypeString,
+					Description: "A Go file of the package declaring or using the symbol",
+				},
+				"symbol": {
+					Type:        genai.TypeString,
+					Description: "The symbol to rename: Func, Type, Type.Method, Type.Field or Var",
+				},
+				"position": {
+					Type:        genai.TypeString,
+					Description: "Position of the identifier as file:line:column or line:column in path (1-based), used instead of symbol for locals and parameters",
+				},
+				"new_name": {
+					Type:        genai.TypeString,
+					Description: "The new name of the identifier",
+				},
+				"dry_run": {
+					Type:        genai.TypeBoolean,
+					Description: "Only return the diff of the rename without writing the files. Defaults to false",
+				},
+			},
+			Required: []string{"path", "new_name"},
+		},
+	}
+
+	return NewTypedTool(declaration, func(ctx context.Context, args renameSymbolArgs) (any, error) {
+		if args.Symbol == "" && args.Position == "" {
+			return nil, fmt.Errorf("symbol or position parameter is required")
+		}
+
+		result, err := goplsTool.Rename(ctx, args.Path, args.Symbol, args.Position, args.NewName, args.DryRun)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rename: %w", err)
+		}
+
+		logger.Info("Function call executed successfully",
+			zap.String("functionName", declaration.Name),
+			zap.String("newName", args.NewName),
+			zap.Int("fileCount", len(result.Files)),
+			zap.Bool("dryRun", args.DryRun))
+
+		return result, nil
+	})
+}
+
+// applyCodeActionArgs are the arguments of apply_code_action
+type applyCodeActionArgs struct {
+	Path        string   `json:"path"`
+	StartLine   int      `json:"start_line"`
+	StartColumn int      `json:"start_column"`
+	EndLine     int      `json:"end_line"`
+	EndColumn   int      `json:"end_column"`
+	Kinds       []string `json:"kinds"`
+	Title       string   `json:"title"`
+	DryRun      bool     `json:"dry_run"`
+}
+
+// codeActionList is the response of apply_code_action when no title is given
+type codeActionList struct {
+	Path    string           `json:"path"`
+	Actions []CodeActionInfo `json:"actions"`
+}
+
+// newApplyCodeActionTool exposes gopls code actions as apply_code_action
+func newApplyCodeActionTool(logger *zap.Logger, goplsTool *GoplsTool) Tool {
+	declaration := &genai.FunctionDeclaration{
+		Name: "apply_code_action",
+		Description: "List or apply gopls code actions (quick fixes, extract function or variable, inline, fill struct, organize imports...) on a range of a Go file. " +
+			"Call it without title to list the available actions, then with the title of the action to apply. Files are written atomically; with dry_run the unified diff is returned without touching the files. Actions running a command other than a gopls fix cannot be previewed",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"path": {
+					Type:        genai.TypeString,
+					Description: "The Go file to refactor",
+				},
+				"start_line": {
+					Type:        genai.TypeInteger,
+					Description: "First line of the range (1-based)",
+				},
+				"start_column": {
+					Type:        genai.TypeInteger,
+					Description: "Column where the range starts on start_line (1-based). Defaults to the start of the line",
+				},
+				"end_line": {
+					Type:        genai.TypeInteger,
+					Description: "Last line of the range (1-based). Defaults to start_line",
+				},
+				"end_column": {
+					Type:        genai.TypeInteger,
+					Description: "Column where the range ends on end_line (1-based, exclusive). Defaults to the end of the line",
+				},
+				"kinds": {
+					Type:        genai.TypeArray,
+					Description: "Only consider actions of these kinds, e.g. quickfix, refactor.extract, refactor.inline, refactor.rewrite, source.organizeImports",
+					Items: &genai.Schema{
+						Type: genai.TypeString,
+					},
+				},
+				"title": {
+					Type:        genai.TypeString,
+					Description: "Title of the action to apply, exactly or as a unique substring. Omit to list the available actions",
+				},
+				"dry_run": {
+					Type:        genai.TypeBoolean,
+					Description: "Only return the diff of the action without writing the files. Defaults to false",
+				},
+			},
+			Required: []string{"path", "start_line"},
+		},
+	}
+
+	return NewTypedTool(declaration, func(ctx context.Context, args applyCodeActionArgs) (any, error) {
+		if args.StartLine < 1 {
+			return nil, fmt.Errorf("start_line must be a 1-based line number")
+		}
+
+		if args.Title == "" {
+			actions, err := goplsTool.ListCodeActions(ctx, args.Path, args.StartLine, args.StartColumn, args.EndLine, args.EndColumn, args.Kinds)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list code actions: %w", err)
+			}
+			return codeActionList{Path: args.Path, Actions: actions}, nil
+		}
+
+		result, err := goplsTool.ApplyCodeAction(ctx, args.Path, args.StartLine, args.StartColumn, args.EndLine, args.EndColumn, args.Kinds, args.Title, args.DryRun)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply code action: %w", err)
+		}
+
+		logger.Info("Function call executed successfully",
+			zap.String("functionName", declaration.Name),
+			zap.String("title", args.Title),
+			zap.Int("fileCount", len(result.Files)),
+			zap.Bool("dryRun", args.DryRun))
+
+		return result, nil
+	})
+}
+
+// formatGoArgs are the arguments of format_go
+type formatGoArgs struct {
+	Path   string `json:"path"`
+	Source string `json:"source"`
+	Write  bool   `json:"write"`
+}
+
+// newFormatGoTool exposes GoplsTool.FormatGo as format_go
+func newFormatGoTool(logger *zap.Logger, goplsTool *GoplsTool) Tool {
+	declaration := &genai.FunctionDeclaration{
+		Name:        "format_go",
+		Description: "Format Go code and organize its imports (add missing, remove unused) with gopls, falling back to gofmt. Returns the cleaned source. Use it to check generated code before returning it",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"path": {
+					Type:        genai.TypeString,
+					Description: "The Go file to format, or the file the source belongs to so that imports resolve against its package. The file is not modified unless write is set",
+				},
+				"source": {
+					Type:        genai.TypeString,
+					Description: "Go source to format instead of the file content. Without path only gofmt formatting is applied, which also accepts snippets of declarations",
+				},
+				"write": {
+					Type:        genai.TypeBoolean,
+					Description: "Write the formatted source to path. Defaults to false",
+				},
+			},
+		},
+	}
+
+	return NewTypedTool(declaration, func(ctx context.Context, args formatGoArgs) (any, error) {
+		if args.Path == "" && args.Source == "" {
+			return nil, fmt.Errorf("path or source parameter is required")
+		}
+		if args.Write && args.Path == "" {
+			return nil, fmt.Errorf("path parameter is required to write the formatted source")
+		}
+
+		result, err := goplsTool.FormatGo(ctx, args.Path, args.Source, args.Write)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Info("Function call executed successfully",
+			zap.String("functionName", declaration.Name),
+			zap.String("path", args.Path),
+			zap.String("formatter", result.Formatter),
+			zap.Bool("changed", result.Changed))
+
+		return result, nil
+	})
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gemini-tool/protocol"
+)
+
+// symbolMatch is a declaration, or a use when nothing is declared locally, that a symbol query resolved to
+type symbolMatch struct {
+	Name     string            // qualified name, e.g. GeminiClient.GenerateContent
+	Kind     string            // func, method, type, struct, interface, field, interface_method, const, var or reference
+	File     string            // file containing the identifier
+	Position protocol.Position // LSP position of the identifier
+}
+
+// AmbiguousSymbolError is returned when a symbol query matches several declarations
+type AmbiguousSymbolError struct {
+	Symbol     string
+	Candidates []string
+}
+
+func (e *AmbiguousSymbolError) Error() string {
+	return fmt.Sprintf("symbol %q is ambiguous, use a qualified name: matches %s",
+		e.Symbol, strings.Join(e.Candidates, ", "))
+}
+
+// SymbolNotFoundError is returned when a symbol query matches nothing
+type SymbolNotFoundError struct {
+	Symbol string
+}
+
+func (e *SymbolNotFoundError) Error() string {
+	return fmt.Sprintf("symbol %q not found", e.Symbol)
+}
+
+// parsedFile is a Go file parsed for symbol resolution
+type parsedFile struct {
+	path    string
+	content []byte
+	fset    *token.FileSet
+	file    *ast.File
+}
+
+// parseGoFile parses a Go file. A partially parsed file is returned for sources with syntax errors.
+func parseGoFile(filePath string, content []byte) (*parsedFile, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filePath, content, parser.ParseComments)
+	if file == nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+
+	return &parsedFile{
+		path:    filePath,
+		content: content,
+		fset:    fset,
+		file:    file,
+	}, nil
+}
+
+// position returns the LSP position of pos in the file
+func (pf *parsedFile) position(pos token.Pos) protocol.Position {
+	return offsetToPosition(pf.content, pf.fset.Position(pos).Offset)
+}
+
+// resolveSymbol finds the identifier a symbol query refers to, starting in filePath.
+//
+// Supported queries are plain names (Func, Type, Var), qualified members (Type.Method,
+// Type.Field, Interface.Method, (*Type).Method), and package-qualified names (pkg.Func),
+// where pkg is either the file's own package or an imported package. Declarations in the
+// file win over declarations elsewhere in its package, which win over uses of the name.
+// A query matching several declarations returns an AmbiguousSymbolError.
+func resolveSymbol(filePath string, content []byte, query string) (*symbolMatch, error) {
+	pf, err := parseGoFile(filePath, content)
+	if err != nil {
+		return nil, err
+	}
+
+	name := normalizeSymbolQuery(query)
+	if name == "" {
+		return nil, fmt.Errorf("empty symbol name")
+	}
+
+	// Strip a qualifier naming the file's own package, e.g. main.GeminiClient
+	if qualifier, rest, ok := strings.Cut(name, "."); ok && qualifier == pf.file.Name.Name {
+		if match, err := resolveInPackage(pf, rest, query); match != nil || err != nil {
+			return match, err
+		}
+	}
+
+	if match, err := resolveInPackage(pf, name, query); match != nil || err != nil {
+		return match, err
+	}
+
+	// Nothing declared in the package: use the first use of the name in the file and let
+	// gopls follow it to its definition (imported packages, promoted members, ...)
+	if match := findSymbolUse(pf, name); match != nil {
+		return match, nil
+	}
+
+	return nil, &SymbolNotFoundError{Symbol: query}
+}
+
+// resolveInPackage looks for declarations of name in the file, then in the other
+// non-test files of its package
+func resolveInPackage(pf *parsedFile, name, query string) (*symbolMatch, error) {
+	if match, err := pickDeclaration(collectDeclarations(pf), name, query); match != nil || err != nil {
+		return match, err
+	}
+
+	var siblings []symbolMatch
+	for _, sibling := range packageSiblings(pf) {
+		siblings = append(siblings, collectDeclarations(sibling)...)
+	}
+	return pickDeclaration(siblings, name, query)
+}
+
+// pickDeclaration selects the declaration matching name. Exact qualified matches win; an
+// unqualified name matches package level declarations first, then members of any type.
+func pickDeclaration(declarations []symbolMatch, name, query string) (*symbolMatch, error) {
+	var matches []symbolMatch
+	for _, decl := range declarations {
+		if decl.Name == name {
+			matches = append(matches, decl)
+		}
+	}
+
+	if len(matches) == 0 && !strings.Contains(name, ".") {
+		for _, decl := range declarations {
+			if strings.HasSuffix(decl.Name, "."+name) {
+				matches = append(matches, decl)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &matches[0], nil
+	default:
+		candidates := make([]string, len(matches))
+		for i, match := range matches {
+			candidates[i] = fmt.Sprintf("%s (%s at %s:%d)", match.Name, match.Kind,
+				filepath.Base(match.File), match.Position.Line+1)
+		}
+		return nil, &AmbiguousSymbolError{Symbol: query, Candidates: candidates}
+	}
+}
+
+// collectDeclarations lists every package level declaration of a file together with the
+// methods, struct fields and interface methods that belong to its types
+func collectDeclarations(pf *parsedFile) []symbolMatch {
+	var declarations []symbolMatch
+	add := func(name, kind string, ident *ast.Ident) {
+		declarations = append(declarations, symbolMatch{
+			Name:     name,
+			Kind:     kind,
+			File:     pf.path,
+			Position: pf.position(ident.Pos()),
+		})
+	}
+
+	for _, decl := range pf.file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				add(d.Name.Name, "func", d.Name)
+				continue
+			}
+			if receiver := receiverTypeName(d.Recv.List[0].Type); receiver != "" {
+				add(receiver+"."+d.Name.Name, "method", d.Name)
+			}
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					switch t := s.Type.(type) {
+					case *ast.StructType:
+						add(s.Name.Name, "struct", s.Name)
+						for _, field := range t.Fields.List {
+							if len(field.Names) == 0 {
+								if embedded := embeddedFieldIdent(field.Type); embedded != nil {
+									add(s.Name.Name+"."+embedded.Name, "field", embedded)
+								}
+								continue
+							}
+							for _, fieldName := range field.Names {
+								add(s.Name.Name+"."+fieldName.Name, "field", fieldName)
+							}
+						}
+					case *ast.InterfaceType:
+						add(s.Name.Name, "interface", s.Name)
+						for _, method := range t.Methods.List {
+							for _, methodName := range method.Names {
+								add(s.Name.Name+"."+methodName.Name, "interface_method", methodName)
+							}
+						}
+					default:
+						add(s.Name.Name, "type", s.Name)
+					}
+
+				case *ast.ValueSpec:
+					kind := "var"
+					if d.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, valueName := range s.Names {
+						if valueName.Name != "_" {
+							add(valueName.Name, kind, valueName)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return declarations
+}
+
+// findSymbolUse returns the first use of name in the file: a selector like pkg.Func or
+// value.Method for qualified names, or a bare identifier otherwise
+func findSymbolUse(pf *parsedFile, name string) *symbolMatch {
+	qualifier, member, qualified := strings.Cut(name, ".")
+
+	var found *ast.Ident
+	ast.Inspect(pf.file, func(node ast.Node) bool {
+		if found != nil {
+			return false
+		}
+
+		switch n := node.(type) {
+		case *ast.SelectorExpr:
+			if qualified {
+				if x, ok := n.X.(*ast.Ident); ok && x.Name == qualifier && n.Sel.Name == member {
+					found = n.Sel
+				}
+			}
+		case *ast.Ident:
+			if !qualified && n.Name == name {
+				found = n
+			}
+		}
+		return true
+	})
+
+	if found == nil {
+		return nil
+	}
+
+	return &symbolMatch{
+		Name:     name,
+		Kind:     "reference",
+		File:     pf.path,
+		Position: pf.position(found.Pos()),
+	}
+}
+
+// packageSiblings parses the other non-test Go files of the file's package
+func packageSiblings(pf *parsedFile) []*parsedFile {
+	dir := filepath.Dir(pf.path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var siblings []*parsedFile
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || path == pf.path {
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		sibling, err := parseGoFile(path, content)
+		if err != nil || sibling.file.Name.Name != pf.file.Name.Name {
+			continue
+		}
+		siblings = append(siblings, sibling)
+	}
+
+	sort.Slice(siblings, func(i, j int) bool { return siblings[i].path < siblings[j].path })
+	return siblings
+}
+
+// normalizeSymbolQuery turns receiver style queries such as (*T).Method or *T into T.Method and T
+func normalizeSymbolQuery(query string) string {
+	name := strings.TrimSpace(query)
+	name = strings.TrimSuffix(name, "()")
+	name = strings.ReplaceAll(name, "(*", "")
+	name = strings.ReplaceAll(name, "(", "")
+	name = strings.ReplaceAll(name, ")", "")
+	return strings.TrimPrefix(name, "*")
+}
+
+// receiverTypeName returns the base type name of a method receiver: *T, T, T[K] and *T[K, V] all give T
+func receiverTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(t.X)
+	case *ast.ParenExpr:
+		return receiverTypeName(t.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(t.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// embeddedFieldIdent returns the identifier naming an embedded field: T, *T, pkg.T and T[K] all give T
+func embeddedFieldIdent(expr ast.Expr) *ast.Ident {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedFieldIdent(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel
+	case *ast.IndexExpr:
+		return embeddedFieldIdent(t.X)
+	case *ast.IndexListExpr:
+		return embeddedFieldIdent(t.X)
+	case *ast.Ident:
+		return t
+	}
+	return nil
+}
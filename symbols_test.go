@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const symbolsTestSource = `package sample
+
+import "strings"
+
+type Client struct {
+	Name string
+	*strings.Builder
+}
+
+func (c *Client) Close() error { return nil }
+
+type Server struct{}
+
+func (s Server) Close() error { return nil }
+
+type Store[K comparable, V any] struct{}
+
+func (s *Store[K, V]) Get(key K) V {
+	var zero V
+	return zero
+}
+
+type Closer interface {
+	Shutdown() error
+}
+
+const Version = "1"
+
+var _, Ready = 0, true
+
+func Run() string {
+	return strings.TrimSpace(" run ")
+}
+`
+
+const symbolsTestSibling = `package sample
+
+func Helper() {}
+`
+
+func TestResolveSymbol(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sample.go")
+	writeTestFile(t, file, symbolsTestSource)
+	writeTestFile(t, filepath.Join(dir, "helper.go"), symbolsTestSibling)
+
+	tests := []struct {
+		query    string
+		wantName string
+		wantKind string
+		wantFile string
+		wantLine int // 0-based
+	}{
+		{query: "Run", wantName: "Run", wantKind: "func", wantLine: 30},
+		{query: "Client", wantName: "Client", wantKind: "struct", wantLine: 4},
+		{query: "Client.Close", wantName: "Client.Close", wantKind: "method", wantLine: 9},
+		{query: "(*Client).Close", wantName: "Client.Close", wantKind: "method", wantLine: 9},
+		{query: "Client.Name", wantName: "Client.Name", wantKind: "field", wantLine: 5},
+		{query: "Client.Builder", wantName: "Client.Builder", wantKind: "field", wantLine: 6},
+		{query: "Store.Get", wantName: "Store.Get", wantKind: "method", wantLine: 17},
+		{query: "Closer.Shutdown", wantName: "Closer.Shutdown", wantKind: "interface_method", wantLine: 23},
+		{query: "Shutdown", wantName: "Closer.Shutdown", wantKind: "interface_method", wantLine: 23},
+		{query: "Version", wantName: "Version", wantKind: "const", wantLine: 26},
+		{query: "Ready", wantName: "Ready", wantKind: "var", wantLine: 28},
+		{query: "sample.Run", wantName: "Run", wantKind: "func", wantLine: 30},
+		{query: "Helper", wantName: "Helper", wantKind: "func", wantFile: "helper.go", wantLine: 2},
+		{query: "strings.TrimSpace", wantName: "strings.TrimSpace", wantKind: "reference", wantLine: 31},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			match, err := resolveSymbol(file, []byte(symbolsTestSource), tt.query)
+			if err != nil {
+				t.Fatalf("resolveSymbol(%q) failed: %v", tt.query, err)
+			}
+
+			wantFile := file
+			if tt.wantFile != "" {
+				wantFile = filepath.Join(dir, tt.wantFile)
+			}
+			if match.Name != tt.wantName || match.Kind != tt.wantKind || match.File != wantFile || match.Position.Line != tt.wantLine {
+				t.Errorf("resolveSymbol(%q) = %s %s at %s:%d, want %s %s at %s:%d", tt.query,
+					match.Kind, match.Name, match.File, match.Position.Line,
+					tt.wantKind, tt.wantName, wantFile, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestResolveSymbolErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sample.go")
+	writeTestFile(t, file, symbolsTestSource)
+
+	t.Run("ambiguous member", func(t *testing.T) {
+		_, err := resolveSymbol(file, []byte(symbolsTestSource), "Close")
+		var ambiguous *AmbiguousSymbolError
+		if !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 2 {
+			t.Errorf("got %v, want an AmbiguousSymbolError with 2 candidates", err)
+		}
+	})
+
+	t.Run("unknown symbol", func(t *testing.T) {
+		_, err := resolveSymbol(file, []byte(symbolsTestSource), "Missing")
+		var notFound *SymbolNotFoundError
+		if !errors.As(err, &notFound) {
+			t.Errorf("got %v, want a SymbolNotFoundError", err)
+		}
+	})
+
+	t.Run("test files are not searched", func(t *testing.T) {
+		writeTestFile(t, filepath.Join(dir, "sample_test.go"), "package sample\n\nfunc TestOnly() {}\n")
+		_, err := resolveSymbol(file, []byte(symbolsTestSource), "TestOnly")
+		var notFound *SymbolNotFoundError
+		if !errors.As(err, &notFound) {
+			t.Errorf("got %v, want a SymbolNotFoundError", err)
+		}
+	})
+
+	t.Run("empty query", func(t *testing.T) {
+		if _, err := resolveSymbol(file, []byte(symbolsTestSource), " () "); err == nil {
+			t.Error("expected an error for an empty query")
+		}
+	})
+}
+
+func TestNormalizeSymbolQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "Run", want: "Run"},
+		{query: " Run() ", want: "Run"},
+		{query: "*Client", want: "Client"},
+		{query: "(*Client).Close", want: "Client.Close"},
+		{query: "(Server).Close", want: "Server.Close"},
+		{query: "Client.Close()", want: "Client.Close"},
+		{query: "pkg.Func", want: "pkg.Func"},
+		{query: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := normalizeSymbolQuery(tt.query); got != tt.want {
+				t.Errorf("normalizeSymbolQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
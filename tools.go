@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/vertexai/genai"
+	"go.uber.org/zap"
+)
+
+// Tool is a function Gemini can call. The declaration sent to the model and the
+// handler that executes it live on the same value, so they cannot drift apart.
+type Tool interface {
+	// Name returns the function name the model uses to call the tool
+	Name() string
+	// Declaration returns the schema declared to the model
+	Declaration() *genai.FunctionDeclaration
+	// Execute runs the tool with the raw arguments of a function call
+	Execute(ctx context.Context, args map[string]any) (any, error)
+}
+
+// typedTool adapts a handler that takes a typed argument struct to the Tool interface
+type typedTool[A any] struct {
+	declaration *genai.FunctionDeclaration
+	handler     func(ctx context.Context, args A) (any, error)
+}
+
+// NewTypedTool creates a Tool whose call arguments are decoded into A before the handler runs
+func NewTypedTool[A any](declaration *genai.FunctionDeclaration, handler func(ctx context.Context, args A) (any, error)) Tool {
+	return &typedTool[A]{
+		declaration: declaration,
+		handler:     handler,
+	}
+}
+
+// Name returns the declared function name
+func (t *typedTool[A]) Name() string {
+	return t.declaration.Name
+}
+
+// Declaration returns the function declaration
+func (t *typedTool[A]) Declaration() *genai.FunctionDeclaration {
+	return t.declaration
+}
+
+// Execute validates and decodes the arguments, then runs the handler
+func (t *typedTool[A]) Execute(ctx context.Context, args map[string]any) (any, error) {
+	if params := t.declaration.Parameters; params != nil {
+		for _, name := range params.Required {
+			if _, ok := args[name]; !ok {
+				return nil, fmt.Errorf("%s parameter is required", name)
+			}
+		}
+	}
+
+	var decoded A
+	if err := DecodeArgs(args, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid arguments for %s: %w", t.Name(), err)
+	}
+
+	return t.handler(ctx, decoded)
+}
+
+// DecodeArgs decodes the loosely typed arguments of a function call into a typed struct
+// using the struct's json tags
+func DecodeArgs(args map[string]any, target any) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("failed to marshal arguments: %w", err)
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("failed to decode arguments: %w", err)
+	}
+
+	return nil
+}
+
+// ToolRegistry holds the tools available to Gemini. It produces the model's tool
+// declarations and dispatches function calls to the matching tool.
+type ToolRegistry struct {
+	mu     sync.RWMutex
+	tools  map[string]Tool
+	order  []string
+	logger *zap.Logger
+}
+
+// NewToolRegistry creates an empty tool registry
+func NewToolRegistry(logger *zap.Logger) *ToolRegistry {
+	return &ToolRegistry{
+		tools:  make(map[string]Tool),
+		logger: logger,
+	}
+}
+
+// Register adds a tool to the registry. Tool names must be unique.
+func (r *ToolRegistry) Register(tool Tool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := tool.Name()
+	if name == "" {
+		return fmt.Errorf("tool name cannot be empty")
+	}
+	if _, exists := r.tools[name]; exists {
+		return fmt.Errorf("tool %q is already registered", name)
+	}
+	if decl := tool.Declaration(); decl == nil || decl.Name != name {
+		return fmt.Errorf("tool %q must declare a function with the same name", name)
+	}
+
+	r.tools[name] = tool
+	r.order = append(r.order, name)
+
+	r.logger.Debug("Registered tool", zap.String("toolName", name))
+	return nil
+}
+
+// Tools returns the declarations of all registered tools, in registration order, for model.Tools
+func (r *ToolRegistry) Tools() []*genai.Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	declarations := make([]*genai.FunctionDeclaration, 0, len(r.order))
+	for _, name := range r.order {
+		declarations = append(declarations, r.tools[name].Declaration())
+	}
+
+	return []*genai.Tool{{FunctionDeclarations: declarations}}
+}
+
+// Dispatch executes a function call with the matching tool and returns the response map
+// to send back to the model
+func (r *ToolRegistry) Dispatch(ctx context.Context, call *genai.FunctionCall) (map[string]any, error) {
+	r.mu.RLock()
+	tool, ok := r.tools[call.Name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown function: %s", call.Name)
+	}
+
+	r.logger.Debug("Dispatching function call", zap.String("functionName", call.Name))
+
+	result, err := tool.Execute(ctx, call.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	return toResponseMap(result)
+}
+
+// toResponseMap converts a tool result into the map carried by a FunctionResponse.
+// Results are round-tripped through JSON so the response only holds plain values.
+func toResponseMap(result any) (map[string]any, error) {
+	if text, ok := result.(string); ok {
+		return map[string]any{"result": text}, nil
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tool result: %w", err)
+	}
+
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode tool result: %w", err)
+	}
+
+	if response, ok := decoded.(map[string]any); ok {
+		return response, nil
+	}
+	return map[string]any{"result": decoded}, nil
+}
+
+// setupTools registers the built-in tools for Gemini. The gopls backed tools share goplsTool.
+func setupTools(logger *zap.Logger, goplsTool *GoplsTool) (*ToolRegistry, error) {
+	registry := NewToolRegistry(logger)
+
+	builtins := []Tool{
+		newAnalyzeGoCodeTool(logger, goplsTool),
+		newRenameSymbolTool(logger, goplsTool),
+		newApplyCodeActionTool(logger, goplsTool),
+		newFormatGoTool(logger, goplsTool),
+		newDirectoryStructureTool(logger),
+		newCodeDefinitionsTool(logger, goplsTool),
+	}
+
+	for _, tool := range builtins {
+		if err := registry.Register(tool); err != nil {
+			return nil, fmt.Errorf("failed to register tool: %w", err)
+		}
+	}
+
+	logger.Info("Configured tools for Gemini", zap.Int("toolCount", len(builtins)))
+
+	return registry, nil
+}
+
+// directoryStructureArgs are the arguments of get_directory_structure
+type directoryStructureArgs struct {
+	Path     string `json:"path"`
+	MaxDepth *int   `json:"max_depth"`
+}
+
+// newDirectoryStructureTool exposes DirectoryStructureTool as get_directory_structure
+func newDirectoryStructureTool(logger *zap.Logger) Tool {
+	dirTool := &DirectoryStructureTool{logger: logger}
+
+	declaration := &genai.FunctionDeclaration{
+		Name:        "get_directory_structure",
+		Description: "Get the directory structure of a given path up to a specified depth",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"path": {
+					Type:        genai.TypeString,
+					Description: "The directory path to analyze",
+				},
+				"max_depth": {
+					Type:        genai.TypeInteger,
+					Description: "Maximum depth to traverse (default: 3)",
+				},
+			},
+			Required: []string{"path"},
+		},
+	}
+
+	return NewTypedTool(declaration, func(ctx context.Context, args directoryStructureArgs) (any, error) {
+		maxDepth := 3 // default
+		if args.MaxDepth != nil {
+			maxDepth = *args.MaxDepth
+		}
+
+		structure, err := dirTool.GetDirectoryStructure(args.Path, maxDepth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get directory structure: %w", err)
+		}
+
+		logger.Info("Function call executed successfully",
+			zap.String("functionName", declaration.Name),
+			zap.String("path", args.Path),
+			zap.Int("maxDepth", maxDepth))
+
+		return structure, nil
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+	"go.uber.org/zap"
+)
+
+// stubTool is a Tool whose behaviour is set by the test
+type stubTool struct {
+	name        string
+	declaration *genai.FunctionDeclaration // defaults to a declaration without parameters
+	execute     func(ctx context.Context, args map[string]any) (any, error)
+}
+
+func (t *stubTool) Name() string {
+	return t.name
+}
+
+func (t *stubTool) Declaration() *genai.FunctionDeclaration {
+	if t.declaration != nil {
+		return t.declaration
+	}
+	return &genai.FunctionDeclaration{Name: t.name}
+}
+
+func (t *stubTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	return t.execute(ctx, args)
+}
+
+// greetArgs are the arguments of the greet test tool
+type greetArgs struct {
+	Name  string `json:"name"`
+	Times int    `json:"times"`
+}
+
+func TestToolRegistryDispatch(t *testing.T) {
+	greet := NewTypedTool(&genai.FunctionDeclaration{
+		Name: "greet",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"name":  {Type: genai.TypeString},
+				"times": {Type: genai.TypeInteger},
+			},
+			Required: []string{"name"},
+		},
+	}, func(ctx context.Context, args greetArgs) (any, error) {
+		return strings.Repeat("hello "+args.Name+" ", args.Times), nil
+	})
+
+	type item struct {
+		Name string `json:"name"`
+		Line int    `json:"line,omitempty"`
+	}
+	result := func(value any) Tool {
+		return NewTypedTool(&genai.FunctionDeclaration{Name: "result"}, func(ctx context.Context, args struct{}) (any, error) {
+			return value, nil
+		})
+	}
+
+	tests := []struct {
+		name    string
+		tool    Tool
+		call    genai.FunctionCall
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name: "typed arguments",
+			tool: greet,
+			call: genai.FunctionCall{Name: "greet", Args: map[string]any{"name": "gopher", "times": 2}},
+			want: map[string]any{"result": "hello gopher hello gopher "},
+		},
+		{
+			name:    "unknown tool",
+			tool:    greet,
+			call:    genai.FunctionCall{Name: "wave", Args: map[string]any{"name": "gopher"}},
+			wantErr: "unknown function: wave",
+		},
+		{
+			name:    "missing required argument",
+			tool:    greet,
+			call:    genai.FunctionCall{Name: "greet", Args: map[string]any{"times": 1}},
+			wantErr: "name parameter is required",
+		},
+		{
+			name:    "argument of the wrong type",
+			tool:    greet,
+			call:    genai.FunctionCall{Name: "greet", Args: map[string]any{"name": "gopher", "times": "twice"}},
+			wantErr: "invalid arguments for greet",
+		},
+		{
+			name: "struct result becomes the response",
+			tool: result(item{Name: "Client", Line: 12}),
+			call: genai.FunctionCall{Name: "result"},
+			want: map[string]any{"name": "Client", "line": float64(12)},
+		},
+		{
+			name: "slice result is wrapped",
+			tool: result([]item{{Name: "Client"}, {Name: "Server"}}),
+			call: genai.FunctionCall{Name: "result"},
+			want: map[string]any{"result": []any{map[string]any{"name": "Client"}, map[string]any{"name": "Server"}}},
+		},
+		{
+			name: "number result is wrapped",
+			tool: result(42),
+			call: genai.FunctionCall{Name: "result"},
+			want: map[string]any{"result": float64(42)},
+		},
+		{
+			name: "nil result is wrapped",
+			tool: result(nil),
+			call: genai.FunctionCall{Name: "result"},
+			want: map[string]any{"result": nil},
+		},
+		{
+			name:    "result that cannot be encoded",
+			tool:    result(func() {}),
+			call:    genai.FunctionCall{Name: "result"},
+			wantErr: "failed to encode tool result",
+		},
+		{
+			name: "handler error",
+			tool: NewTypedTool(&genai.FunctionDeclaration{Name: "broken"}, func(ctx context.Context, args struct{}) (any, error) {
+				return nil, errors.New("gopls is not running")
+			}),
+			call:    genai.FunctionCall{Name: "broken"},
+			wantErr: "gopls is not running",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewToolRegistry(zap.NewNop())
+			if err := registry.Register(tt.tool); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := registry.Dispatch(context.Background(), &tt.call)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Dispatch() = %v, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dispatch() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Dispatch() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolRegistryRegister(t *testing.T) {
+	tool := func(name, declared string) Tool {
+		return &stubTool{name: name, declaration: &genai.FunctionDeclaration{Name: declared}}
+	}
+
+	tests := []struct {
+		name    string
+		tools   []Tool
+		wantErr bool
+	}{
+		{name: "distinct tools", tools: []Tool{tool("a", "a"), tool("b", "b")}},
+		{name: "duplicate name", tools: []Tool{tool("a", "a"), tool("a", "a")}, wantErr: true},
+		{name: "empty name", tools: []Tool{tool("", "")}, wantErr: true},
+		{name: "declaration with another name", tools: []Tool{tool("a", "b")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewToolRegistry(zap.NewNop())
+
+			var err error
+			for _, tool := range tt.tools {
+				if err = registry.Register(tool); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Register() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
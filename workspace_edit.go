@@ -0,0 +1,544 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gemini-tool/protocol"
+)
+
+// fileChange is the pending change of one file produced by workspace edits
+type fileChange struct {
+	path    string // current path; the new path for renamed files
+	oldPath string // original path of a renamed file
+	before  []byte
+	after   []byte
+	mode    os.FileMode // permissions before the edits, kept when the file is written or restored
+	existed bool        // the file existed before the edits
+	deleted bool
+}
+
+// action describes the change made to the file
+func (fc *fileChange) action() string {
+	switch {
+	case fc.deleted:
+		return "deleted"
+	case !fc.existed:
+		return "created"
+	case fc.oldPath != "":
+		return "renamed"
+	default:
+		return "modified"
+	}
+}
+
+// editSession accumulates workspace edits in memory so they can be previewed as a diff or
+// written to disk at once. Later edits apply on top of earlier ones.
+type editSession struct {
+	changes map[string]*fileChange
+	order   []string
+}
+
+func newEditSession() *editSession {
+	return &editSession{changes: make(map[string]*fileChange)}
+}
+
+// file returns the pending change of path, loading its current content on first use
+func (s *editSession) file(path string) (*fileChange, error) {
+	if change, ok := s.changes[path]; ok {
+		return change, nil
+	}
+
+	content, err := os.ReadFile(path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	mode := os.FileMode(0o644)
+	if existed {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		mode = info.Mode().Perm()
+	}
+
+	change := &fileChange{path: path, before: content, after: content, mode: mode, existed: existed}
+	s.changes[path] = change
+	s.order = append(s.order, path)
+	return change, nil
+}
+
+// add applies a workspace edit to the pending changes
+func (s *editSession) add(edit protocol.WorkspaceEdit) error {
+	uris := make([]string, 0, len(edit.Changes))
+	for uri := range edit.Changes {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	for _, uri := range uris {
+		if err := s.applyTextEdits(uriToPath(uri), edit.Changes[uri]); err != nil {
+			return err
+		}
+	}
+
+	for _, change := range edit.DocumentChanges {
+		var err error
+		switch change.Kind {
+		case "":
+			if change.TextDocument == nil {
+				return fmt.Errorf("document change without text document")
+			}
+			err = s.applyTextEdits(uriToPath(change.TextDocument.URI), change.Edits)
+		case "create":
+			err = s.create(uriToPath(change.URI))
+		case "rename":
+			err = s.rename(uriToPath(change.OldURI), uriToPath(change.NewURI))
+		case "delete":
+			err = s.remove(uriToPath(change.URI))
+		default:
+			err = fmt.Errorf("unsupported resource operation %q", change.Kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyTextEdits applies LSP text edits to the pending content of path
+func (s *editSession) applyTextEdits(path string, edits []protocol.TextEdit) error {
+	change, err := s.file(path)
+	if err != nil {
+		return err
+	}
+	if change.deleted || (!change.existed && change.after == nil) {
+		return fmt.Errorf("cannot edit %s: file does not exist", path)
+	}
+
+	content, err := applyTextEdits(change.after, edits)
+	if err != nil {
+		return fmt.Errorf("cannot edit %s: %w", path, err)
+	}
+	change.after = content
+	return nil
+}
+
+// applyTextEdits applies LSP text edits, which all refer to content before the edits, and
+// returns the edited copy
+func applyTextEdits(content []byte, edits []protocol.TextEdit) ([]byte, error) {
+	type span struct {
+		start, end int
+		text       string
+	}
+	spans := make([]span, len(edits))
+	for i, edit := range edits {
+		spans[i] = span{
+			start: positionToOffset(content, edit.Range.Start),
+			end:   positionToOffset(content, edit.Range.End),
+			text:  edit.NewText,
+		}
+		if spans[i].start > spans[i].end {
+			return nil, fmt.Errorf("invalid edit range")
+		}
+	}
+
+	// Build the result front to back. Inserts at the same offset keep their listed order and
+	// come before a replacement starting there.
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end < spans[j].end
+	})
+
+	edited := make([]byte, 0, len(content))
+	offset := 0
+	for _, sp := range spans {
+		if sp.start < offset {
+			return nil, fmt.Errorf("overlapping edits")
+		}
+		edited = append(edited, content[offset:sp.start]...)
+		edited = append(edited, sp.text...)
+		offset = sp.end
+	}
+	return append(edited, content[offset:]...), nil
+}
+
+func (s *editSession) create(path string) error {
+	change, err := s.file(path)
+	if err != nil {
+		return err
+	}
+	if change.existed && !change.deleted {
+		return nil // LSP create on an existing file without overwrite is ignored
+	}
+	change.deleted = false
+	change.after = []byte{}
+	return nil
+}
+
+func (s *editSession) rename(oldPath, newPath string) error {
+	change, err := s.file(oldPath)
+	if err != nil {
+		return err
+	}
+	if change.deleted || !change.existed {
+		return fmt.Errorf("cannot rename %s: file does not exist", oldPath)
+	}
+
+	if _, exists := s.changes[newPath]; exists || fileExists(newPath) {
+		return fmt.Errorf("cannot rename %s: %s already exists", oldPath, newPath)
+	}
+
+	delete(s.changes, oldPath)
+	change.oldPath = oldPath
+	change.path = newPath
+	s.changes[newPath] = change
+	for i, path := range s.order {
+		if path == oldPath {
+			s.order[i] = newPath
+		}
+	}
+	return nil
+}
+
+func (s *editSession) remove(path string) error {
+	change, err := s.file(path)
+	if err != nil {
+		return err
+	}
+	change.deleted = true
+	change.after = nil
+	return nil
+}
+
+// files returns the changed files in the order they were first edited
+func (s *editSession) files() []*fileChange {
+	var files []*fileChange
+	for _, path := range s.order {
+		change := s.changes[path]
+		if change.existed && !change.deleted && change.oldPath == "" && string(change.before) == string(change.after) {
+			continue
+		}
+		files = append(files, change)
+	}
+	return files
+}
+
+// commit writes every change to disk. Each file is written to a temporary file first; the
+// temporary files only replace the originals once all of them were written, and files
+// already replaced are restored if a later replacement fails.
+func (s *editSession) commit() error {
+	files := s.files()
+
+	temps := make(map[*fileChange]string)
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}()
+
+	for _, change := range files {
+		if change.deleted {
+			continue
+		}
+
+		temp, err := writeTempFile(change.path, change.after, change.mode)
+		if err != nil {
+			return err
+		}
+		temps[change] = temp
+	}
+
+	var done []*fileChange
+	for _, change := range files {
+		var err error
+		if change.deleted {
+			err = os.Remove(change.path)
+		} else {
+			err = os.Rename(temps[change], change.path)
+			if err == nil {
+				delete(temps, change)
+				if change.oldPath != "" {
+					err = os.Remove(change.oldPath)
+				}
+			}
+		}
+
+		if err != nil {
+			if restoreErr := restoreFiles(done); restoreErr != nil {
+				return fmt.Errorf("failed to write %s, and rolling back the changes failed: %w", change.path, errors.Join(err, restoreErr))
+			}
+			return fmt.Errorf("failed to write %s, changes rolled back: %w", change.path, err)
+		}
+		done = append(done, change)
+	}
+
+	return nil
+}
+
+// restoreFiles puts back the original content and permissions of files written by commit
+// and returns the files it could not restore
+func restoreFiles(files []*fileChange) error {
+	var errs []error
+	for _, change := range files {
+		if change.oldPath != "" {
+			if err := os.Remove(change.path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("failed to remove %s: %w", change.path, err))
+			}
+			if err := restoreFile(change.oldPath, change.before, change.mode); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if !change.existed {
+			if err := os.Remove(change.path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("failed to remove %s: %w", change.path, err))
+			}
+			continue
+		}
+		if err := restoreFile(change.path, change.before, change.mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// restoreFile writes back the original content of path with its original permissions
+func restoreFile(path string, data []byte, mode os.FileMode) error {
+	temp, err := writeTempFile(path, data, mode)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeTempFile writes data to a temporary file next to path and returns its name
+func writeTempFile(path string, data []byte, perm os.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", fmt.Errorf("failed to write temporary file for %s: %w", path, err)
+	}
+
+	return temp.Name(), nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file and a rename
+func writeFileAtomic(path string, data []byte) error {
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	temp, err := writeTempFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// diff returns the unified diff of the change
+func (fc *fileChange) diff() string {
+	oldName, newName := fc.path, fc.path
+	if fc.oldPath != "" {
+		oldName = fc.oldPath
+	}
+	if !fc.existed {
+		oldName = "/dev/null"
+	}
+	if fc.deleted {
+		newName = "/dev/null"
+	}
+	return unifiedDiff(oldName, newName, string(fc.before), string(fc.after), 3)
+}
+
+// unifiedDiff returns a unified diff between two texts with the given number of context lines
+func unifiedDiff(oldName, newName, before, after string, context int) string {
+	oldLines := splitLines(before)
+	newLines := splitLines(after)
+	ops := diffLines(oldLines, newLines)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk until a run of unchanged lines longer than twice the context
+		hunkStart := max(start-context, 0)
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end = min(end+context, len(ops))
+				break
+			}
+			end = run
+		}
+
+		oldStart, newStart, oldCount, newCount := 0, 0, 0, 0
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				oldStart++
+			}
+			if op.kind != '-' {
+				newStart++
+			}
+		}
+		for _, op := range ops[hunkStart:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, op := range ops[hunkStart:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = end
+	}
+
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines computes a line diff from the longest common subsequence of the lines
+// between the common prefix and suffix
+func diffLines(oldLines, newLines []string) []diffOp {
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	a := oldLines[prefix : len(oldLines)-suffix]
+	b := newLines[prefix : len(newLines)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(oldLines)+len(newLines))
+	for _, line := range oldLines[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+
+	for _, line := range oldLines[len(oldLines)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// splitLines splits text into lines that keep their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gemini-tool/protocol"
+)
+
+// textEdit builds an edit replacing the 0-based range startLine:startChar-endLine:endChar
+func textEdit(startLine, startChar, endLine, endChar int, text string) protocol.TextEdit {
+	return protocol.TextEdit{
+		Range: protocol.Range{
+			Start: protocol.Position{Line: startLine, Character: startChar},
+			End:   protocol.Position{Line: endLine, Character: endChar},
+		},
+		NewText: text,
+	}
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		edits   []protocol.TextEdit
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "no edits",
+			content: "package main\n",
+			want:    "package main\n",
+		},
+		{
+			name:    "single replacement",
+			content: "func old() {}\n",
+			edits:   []protocol.TextEdit{textEdit(0, 5, 0, 8, "renamed")},
+			want:    "func renamed() {}\n",
+		},
+		{
+			name:    "edits listed out of order",
+			content: "a b c\n",
+			edits:   []protocol.TextEdit{textEdit(0, 4, 0, 5, "C"), textEdit(0, 0, 0, 1, "A")},
+			want:    "A b C\n",
+		},
+		{
+			name:    "inserts at the same offset keep their order",
+			content: "import ()\n",
+			edits: []protocol.TextEdit{
+				textEdit(0, 8, 0, 8, "\"fmt\";"),
+				textEdit(0, 8, 0, 8, "\"os\";"),
+				textEdit(0, 8, 0, 8, "\"strings\""),
+			},
+			want: "import (\"fmt\";\"os\";\"strings\")\n",
+		},
+		{
+			name:    "replacement followed by an adjacent insert",
+			content: "x := 1\n",
+			edits:   []protocol.TextEdit{textEdit(0, 0, 0, 1, "y"), textEdit(0, 1, 0, 1, "z")},
+			want:    "yz := 1\n",
+		},
+		{
+			name:    "insert listed after a replacement at the same offset comes first",
+			content: "x := 1\n",
+			edits:   []protocol.TextEdit{textEdit(0, 0, 0, 1, "y"), textEdit(0, 0, 0, 0, "var ")},
+			want:    "var y := 1\n",
+		},
+		{
+			name:    "multi-line edits",
+			content: "package main\n\nfunc a() {}\n\nfunc b() {}\n",
+			edits:   []protocol.TextEdit{textEdit(1, 0, 3, 0, ""), textEdit(4, 5, 4, 6, "c")},
+			want:    "package main\n\nfunc c() {}\n",
+		},
+		{
+			name:    "UTF-16 positions",
+			content: "s := \"😀x\"\n",
+			edits:   []protocol.TextEdit{textEdit(0, 8, 0, 9, "y")},
+			want:    "s := \"😀y\"\n",
+		},
+		{
+			name:    "insert at the end",
+			content: "a",
+			edits:   []protocol.TextEdit{textEdit(0, 1, 0, 1, "\n")},
+			want:    "a\n",
+		},
+		{
+			name:    "overlapping edits",
+			content: "abcdef\n",
+			edits:   []protocol.TextEdit{textEdit(0, 0, 0, 3, "x"), textEdit(0, 2, 0, 4, "y")},
+			wantErr: true,
+		},
+		{
+			name:    "nested edits",
+			content: "abcdef\n",
+			edits:   []protocol.TextEdit{textEdit(0, 0, 0, 5, "x"), textEdit(0, 1, 0, 2, "y")},
+			wantErr: true,
+		},
+		{
+			name:    "reversed range",
+			content: "abcdef\n",
+			edits:   []protocol.TextEdit{textEdit(0, 4, 0, 2, "x")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyTextEdits([]byte(tt.content), tt.edits)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("applyTextEdits() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyTextEdits() failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("applyTextEdits() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommitRollback(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	secret := filepath.Join(dir, "secret.env")
+	gone := filepath.Join(dir, "gone.go")
+	for path, mode := range map[string]os.FileMode{script: 0o755, secret: 0o600, gone: 0o644} {
+		if err := os.WriteFile(path, []byte("before\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	session := newEditSession()
+	edit := protocol.WorkspaceEdit{Changes: map[string][]protocol.TextEdit{
+		pathToURI(script): {textEdit(0, 0, 0, 6, "after")},
+		pathToURI(secret): {textEdit(0, 0, 0, 6, "after")},
+	}}
+	if err := session.add(edit); err != nil {
+		t.Fatal(err)
+	}
+	// The renamed file is written again by the rollback, so it only keeps its mode if restored with it
+	renamed := filepath.Join(dir, "start.sh")
+	if err := session.rename(script, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.remove(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing gone.go fails once the other files were replaced, which rolls them back
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	err := session.commit()
+	if err == nil || !strings.Contains(err.Error(), "changes rolled back") {
+		t.Fatalf("commit() = %v, want a rolled back error", err)
+	}
+
+	if _, err := os.Stat(renamed); !os.IsNotExist(err) {
+		t.Errorf("start.sh still exists after rollback: %v", err)
+	}
+	for path, mode := range map[string]os.FileMode{script: 0o755, secret: 0o600} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "before\n" {
+			t.Errorf("%s = %q after rollback, want %q", filepath.Base(path), content, "before\n")
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s has mode %v after rollback, want %v", filepath.Base(path), info.Mode().Perm(), mode)
+		}
+	}
+}
+
+func TestRestoreFilesReportsFailures(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A regular file stands where the directory of the restored file should be
+	err := restoreFiles([]*fileChange{{path: filepath.Join(blocker, "main.go"), before: []byte("package main\n"), mode: 0o644, existed: true}})
+	if err == nil || !strings.Contains(err.Error(), "main.go") {
+		t.Errorf("restoreFiles() = %v, want an error naming main.go", err)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  string
+		after   string
+		context int
+		want    string
+	}{
+		{
+			name:    "identical",
+			before:  "a\nb\n",
+			after:   "a\nb\n",
+			context: 3,
+			want:    "--- old\n+++ new\n",
+		},
+		{
+			name:    "changed line with context",
+			before:  "a\nb\nc\nd\ne\n",
+			after:   "a\nb\nC\nd\ne\n",
+			context: 1,
+			want:    "--- old\n+++ new\n@@ -2,3 +2,3 @@\n b\n-c\n+C\n d\n",
+		},
+		{
+			name:    "distant changes give separate hunks",
+			before:  "1\n2\n3\n4\n5\n6\n7\n8\n",
+			after:   "one\n2\n3\n4\n5\n6\n7\neight\n",
+			context: 1,
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n-1\n+one\n 2\n@@ -7,2 +7,2 @@\n 7\n-8\n+eight\n",
+		},
+		{
+			name:    "close changes share a hunk",
+			before:  "1\n2\n3\n4\n",
+			after:   "one\n2\n3\nfour\n",
+			context: 1,
+			want:    "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n-4\n+four\n",
+		},
+		{
+			name:    "new file",
+			before:  "",
+			after:   "a\nb\n",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:    "deleted file",
+			before:  "a\n",
+			after:   "",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name:    "missing final newline",
+			before:  "a\nb",
+			after:   "a\nc",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", tt.before, tt.after, tt.context); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}